@@ -0,0 +1,273 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// Acknowledgement tells the Listener what to do with a delivered message.
+type Acknowledgement int
+
+const (
+	// Ack means the message was processed, so the Listener commits it.
+	Ack Acknowledgement = iota
+	// Nack means the message was not processed, so the Listener logs it and skips it without committing it,
+	// unless WithRetryTopics is set.
+	Nack
+	// Drop means the message cannot be processed, so the Listener hands it to the dropped message handler.
+	Drop
+)
+
+// DropSemantics tells the Listener who owns a dropped message, see WithDropSemantics.
+// If it's not set, the dropped message handler is called and, only if the processing of the message
+// timed out, the message is committed once the handler succeeds, like DropTerminal. Otherwise the
+// message is not committed, so it may be handled again if it's redelivered.
+type DropSemantics int
+
+const (
+	// DropTerminal means the dropped message handler owns the message, so the Listener commits
+	// it once the handler succeeds, e.g. once it's sent to a dead-letter topic. If the handler fails,
+	// the message is left uncommitted to be redelivered.
+	DropTerminal DropSemantics = iota + 1
+	// DropRedeliver means the message is left to be redelivered, so the Listener neither calls
+	// the dropped message handler nor commits it.
+	DropRedeliver
+	// DropInformational means the dropped message handler is called but its result is only logged,
+	// so the message is never committed, even if its processing timed out.
+	DropInformational
+)
+
+var (
+	ErrMessageNacked = errors.New("message nacked")
+)
+
+// AckFromError maps the error channel protocol onto an Acknowledgement:
+// nil is an Ack and any error is a Nack.
+func AckFromError(err error) Acknowledgement {
+	if err == nil {
+		return Ack
+	}
+
+	return Nack
+}
+
+// acknowledged returns the messages of the batch that the given error commits.
+// A nil error commits the whole batch, a PartialBatchError commits only the processed
+// messages and any other error commits nothing.
+func acknowledged(err error, batch []kafka.Message) []kafka.Message {
+	if err == nil {
+		return batch
+	}
+
+	var partial *PartialBatchError
+	if !errors.As(err, &partial) {
+		return nil
+	}
+
+	processed := partial.Processed
+	if processed < 0 {
+		processed = 0
+	} else if processed > len(batch) {
+		processed = len(batch)
+	}
+
+	return batch[:processed]
+}
+
+// withoutGaps returns the processed messages that precede every unprocessed message of their
+// partition, as committing a message commits its partition up to its offset. It only matters
+// when the messages aren't in the order they were fetched, see WithBatchByKey.
+func withoutGaps(processed, messages []kafka.Message) []kafka.Message {
+	type position struct {
+		partition TopicPartition
+		offset    int64
+	}
+
+	done := make(map[position]bool, len(processed))
+
+	for _, message := range processed {
+		done[position{TopicPartition{Topic: message.Topic, Partition: message.Partition}, message.Offset}] = true
+	}
+
+	// The lowest offset left unprocessed of every partition.
+	gaps := map[TopicPartition]int64{}
+
+	for _, message := range messages {
+		partition := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+		if done[position{partition, message.Offset}] {
+			continue
+		}
+
+		if gap, ok := gaps[partition]; !ok || message.Offset < gap {
+			gaps[partition] = message.Offset
+		}
+	}
+
+	if len(gaps) == 0 {
+		return processed
+	}
+
+	committable := make([]kafka.Message, 0, len(processed))
+
+	for _, message := range processed {
+		gap, ok := gaps[TopicPartition{Topic: message.Topic, Partition: message.Partition}]
+		if ok && message.Offset > gap {
+			continue
+		}
+
+		committable = append(committable, message)
+	}
+
+	return committable
+}
+
+// resolveAck logs, skips or drops the delivered messages according to the acknowledgement
+// and returns the ones that must be committed. err is the error sent on the error channel, if any.
+func (listener *Listener) resolveAck(ctx context.Context, ack Acknowledgement, err error, messages []kafka.Message) []kafka.Message {
+	switch ack {
+	case Ack:
+		return messages
+
+	case Drop:
+		return listener.dropMessages(ctx, messages, ErrMessageDropped)
+
+	case Nack:
+		if err == nil {
+			err = ErrMessageNacked
+		}
+
+		listener.log.Errorf(err, "Failed to process message =%v", messages)
+
+		processed := acknowledged(err, messages)
+
+		// Schedule the unprocessed messages to the next retry tier, see WithRetryTopics.
+		if len(listener.opts.retryTiers) > 0 {
+			processed = append(processed, listener.retryMessages(ctx, messages[len(processed):], err)...)
+		}
+
+		return withoutGaps(processed, messages)
+	}
+
+	return nil
+}
+
+// handleAck resolves the acknowledgement of the delivered messages and commits the acknowledged ones.
+func (listener *Listener) handleAck(ctx context.Context, ack Acknowledgement, err error, messages ...kafka.Message) error {
+	processed := listener.resolveAck(ctx, ack, err, messages)
+	if len(processed) == 0 {
+		return nil
+	}
+
+	// Commit the processed messages as a unit.
+	if err := listener.doCommitMessage(ctx, processed...); err != nil {
+		return errors.Wrap(err, "err := listener.doCommitMessage(ctx, processed...)")
+	}
+
+	return nil
+}
+
+// dropMessages processes every given message as a dropped message, following the drop semantics,
+// and returns the ones that must be committed. reason is why they were dropped, see DropReason.
+func (listener *Listener) dropMessages(ctx context.Context, messages []kafka.Message, reason error) []kafka.Message {
+	var terminal []kafka.Message
+
+	ctx = listener.withDropReason(ctx, reason)
+
+	for index := range messages {
+		listener.reportOutcome(OutcomeDropped, messages[index])
+
+		if listener.opts.dropSemantics == DropRedeliver {
+			listener.opts.metricMessagesDropped.Inc()
+			listener.log.Printf("Message dropped, it will be redelivered (partition = %d, offset = %d)",
+				messages[index].Partition, messages[index].Offset)
+
+			continue
+		}
+
+		if err := listener.opts.processDroppedMsg(ctx, &messages[index], listener.log); err != nil {
+			listener.opts.metricMessagesDropped.Inc()
+			listener.log.Errorf(err, "Failed to process message")
+
+			continue
+		}
+
+		listener.opts.metricMessagesRescued.Inc()
+
+		if listener.commitsDropped(reason) {
+			terminal = append(terminal, messages[index])
+		}
+	}
+
+	return terminal
+}
+
+// commitsDropped reports whether the messages dropped for the given reason are committed once the
+// dropped message handler succeeds, see DropSemantics.
+func (listener *Listener) commitsDropped(reason error) bool {
+	switch listener.opts.dropSemantics {
+	case DropTerminal:
+		return true
+	case DropRedeliver, DropInformational:
+		return false
+	}
+
+	// By default, the handler owns the messages whose processing timed out.
+	return errors.Is(reason, ErrProcessingTimeout)
+}
+
+// commitFailed handles the messages a handler failed to process, see failMessages, and commits the ones
+// republished to a retry topic or taken over by the dropped message handler.
+func (listener *Listener) commitFailed(ctx context.Context, reason error, messages ...kafka.Message) error {
+	processed := listener.failMessages(ctx, messages, reason)
+	if len(processed) == 0 {
+		return nil
+	}
+
+	if err := listener.doCommitMessage(ctx, processed...); err != nil {
+		return errors.Wrap(err, "err := listener.doCommitMessage(ctx, processed...)")
+	}
+
+	return nil
+}
+
+// commitDropped processes the given messages as dropped messages and commits the ones
+// the dropped message handler took over, see DropTerminal.
+func (listener *Listener) commitDropped(ctx context.Context, reason error, messages ...kafka.Message) error {
+	terminal := listener.dropMessages(ctx, messages, reason)
+	if len(terminal) == 0 {
+		return nil
+	}
+
+	if err := listener.doCommitMessage(ctx, terminal...); err != nil {
+		return errors.Wrap(err, "err := listener.doCommitMessage(ctx, terminal...)")
+	}
+
+	return nil
+}
+
+// lateErrors returns the error channel if WithUnbufferedAck is set, so the deliveries receive the
+// late errors meanwhile, otherwise a nil channel that never fires.
+func (listener *Listener) lateErrors() <-chan error {
+	if !listener.opts.unbufferedAck {
+		return nil
+	}
+
+	return listener.errorChan
+}
+
+// lateAcks is the counterpart of lateErrors for the acknowledgement channel.
+func (listener *Listener) lateAcks() <-chan Acknowledgement {
+	if !listener.opts.unbufferedAck {
+		return nil
+	}
+
+	return listener.ackChan
+}
+
+// discardLateAck discards an acknowledgement received while delivering a message, which belongs to
+// a previous message whose processing timed out, instead of attributing it to the next one.
+func (listener *Listener) discardLateAck() {
+	listener.log.Printf("Discarding the late acknowledgement of a timed out message")
+}
@@ -0,0 +1,47 @@
+package kafko
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// GroupByKey splits a batch into the groups of messages sharing a key, keeping the order of the
+// messages within a group, in the order the keys first appear in the batch. The batches delivered
+// with WithBatchByKey are already sorted that way, so the groups are consecutive slices of the batch.
+func GroupByKey(batch []kafka.Message) [][]kafka.Message {
+	groups := [][]kafka.Message{}
+	indexes := map[string]int{}
+
+	for _, message := range batch {
+		index, ok := indexes[string(message.Key)]
+		if !ok {
+			index = len(groups)
+			indexes[string(message.Key)] = index
+			groups = append(groups, nil)
+		}
+
+		groups[index] = append(groups[index], message)
+	}
+
+	return groups
+}
+
+// sortByKey sorts the batch so the messages sharing a key are consecutive, see GroupByKey.
+func sortByKey(batch []kafka.Message) []kafka.Message {
+	sorted := make([]kafka.Message, 0, len(batch))
+
+	for _, group := range GroupByKey(batch) {
+		sorted = append(sorted, group...)
+	}
+
+	return sorted
+}
+
+// batchFull reports whether a batch of the given number of messages and keys is full.
+// With WithBatchByKey, the batch is full once it holds the maximum number of keys.
+func (listener *Listener) batchFull(messages, keys int) bool {
+	if listener.opts.batchMaxKeys > 0 {
+		return keys >= listener.opts.batchMaxKeys
+	}
+
+	return messages >= listener.opts.batchMaxSize
+}
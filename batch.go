@@ -0,0 +1,214 @@
+package kafko
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// PartialBatchError reports that only the first Processed messages of a batch
+// were processed successfully. Send it on the error channel to commit those
+// messages; the rest of the batch is handled as a failed message.
+type PartialBatchError struct {
+	Processed int   // Number of leading messages of the batch that were processed.
+	Err       error // Reason why the rest of the batch could not be processed.
+}
+
+func (err *PartialBatchError) Error() string {
+	return fmt.Sprintf("batch partially processed (%d messages): %v", err.Processed, err.Err)
+}
+
+func (err *PartialBatchError) Unwrap() error {
+	return err.Err
+}
+
+// fetchBatch fetches messages until the batch is full or the batch window elapses.
+// It returns the messages fetched so far along with the error that interrupted the batch, if any.
+// With WithBatchByKey, the messages are sorted by key, see GroupByKey.
+func (listener *Listener) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	batch, err := listener.fetchBatchMessages(ctx)
+
+	if listener.opts.batchMaxKeys > 0 {
+		batch = sortByKey(batch)
+	}
+
+	return batch, err
+}
+
+// fetchBatchMessages fetches the messages of a batch in the order they were fetched, see fetchBatch.
+func (listener *Listener) fetchBatchMessages(ctx context.Context) ([]kafka.Message, error) {
+	batchCtx, cancel := context.WithTimeout(ctx, listener.opts.batchMaxWait)
+	defer cancel()
+
+	batch := make([]kafka.Message, 0, listener.opts.batchMaxSize)
+	keys := map[string]bool{}
+
+	for !listener.batchFull(len(batch), len(keys)) {
+		message, err := listener.fetchMessage(batchCtx)
+		if err != nil {
+			// The batch window elapsed, so deliver what we have so far.
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return batch, nil
+			}
+
+			return batch, errors.Wrap(err, "message, err := listener.fetchMessage(batchCtx)")
+		}
+
+		listener.opts.metricMessagesFetched.Inc()
+		listener.markStarted()
+
+		if listener.skipMessage(message) {
+			continue
+		}
+
+		keys[string(message.Key)] = true
+		batch = append(batch, message)
+	}
+
+	return batch, nil
+}
+
+// processBatchTick fetches a batch of messages and delivers it through the batch channel.
+func (listener *Listener) processBatchTick(ctx context.Context) error {
+	// Don't fetch while drained, see Drain.
+	if err := listener.awaitResume(ctx); err != nil {
+		return err
+	}
+
+	listener.processing.Lock()
+
+	defer listener.processing.Unlock()
+
+	select {
+	case <-listener.shuttingDownCh:
+		return errExitProcessingLoop
+	default:
+	}
+
+	if listener.draining() {
+		return nil
+	}
+
+	batch, fetchErr := listener.fetchBatch(ctx)
+
+	// A batch window that elapsed without a message isn't a fetch, see markStarted.
+	if len(batch) > 0 {
+		listener.checkGeneration(ctx)
+	}
+
+	for _, message := range batch {
+		listener.checkpoint(ctx, message)
+	}
+
+	// Commit the batch before its delivery, see CommitBeforeProcess.
+	if len(batch) > 0 {
+		deliverable, err := listener.commitBeforeProcess(ctx, batch...)
+		if err != nil {
+			return err
+		}
+
+		if !deliverable {
+			batch = nil
+		}
+	}
+
+	// Deliver the messages fetched before any error, otherwise they would be skipped.
+	if len(batch) > 0 {
+		listener.observeMessages(batch...)
+
+		if err := listener.processBatchAndError(listener.withGeneration(ctx), batch); err != nil {
+			return errors.Wrap(err, "err := listener.processBatchAndError(ctx, batch)")
+		}
+	}
+
+	// If there's an error, handle the error and continue to the next iteration.
+	if fetchErr != nil {
+		listener.opts.metricErrors.Inc()
+
+		// Let the prefetching go on once the error was handled, see WithPrefetch.
+		defer listener.resumePrefetch()
+
+		if err := listener.handleKafkaError(ctx, fetchErr); err != nil {
+			return errors.Wrap(err, "err := listener.handleKafkaError(ctx, fetchErr)")
+		}
+	}
+
+	return nil
+}
+
+// processBatchAndError delivers the batch and handles the acknowledgement of the consumer,
+// following a similar approach to processMessageAndError.
+func (listener *Listener) processBatchAndError(ctx context.Context, batch []kafka.Message) error {
+	start := time.Now()
+
+	defer listener.startDelivery(ctx)()
+
+	deliveryTimeout := time.After(listener.batchDeliveryTimeout(batch))
+
+	for {
+		select {
+		case listener.batchChan <- batch:
+			// Process the batch and handle any errors.
+			if err := listener.processBatchError(ctx, batch, start); err != nil {
+				return errors.Wrap(err, "err := listener.processBatchError(ctx, batch, start)")
+			}
+
+			duration := time.Since(start)
+			listener.opts.metricDurationProcess.Observe(float64(duration.Milliseconds()))
+
+			return nil
+
+		case <-listener.lateErrors():
+			listener.discardLateAck()
+
+		case <-listener.lateAcks():
+			listener.discardLateAck()
+
+		case <-deliveryTimeout:
+			// Attempt to empty the listener.batchChan channel if there is a batch.
+			select {
+			case _, closed := <-listener.batchChan:
+				if closed {
+					// If the listener.batchChan has been closed, exit the loop.
+					return nil
+				}
+			default:
+			}
+
+			listener.cancelDelivery()
+			listener.onTimeout(start, batch...)
+
+			return listener.commitDropped(ctx, ErrProcessingTimeout, batch...)
+		}
+	}
+}
+
+// processBatchError waits for the acknowledgement of the batch and commits the acknowledged messages.
+// start is the time the delivery started.
+func (listener *Listener) processBatchError(ctx context.Context, batch []kafka.Message, start time.Time) error {
+	delivered := time.Now()
+
+	select {
+	case err := <-listener.errorChan:
+		listener.observeAckLatency(delivered)
+
+		return listener.handleAck(ctx, AckFromError(err), err, batch...)
+
+	case ack := <-listener.ackChan:
+		listener.observeAckLatency(delivered)
+
+		return listener.handleAck(ctx, ack, nil, batch...)
+
+	case <-time.After(listener.batchAckTimeout(batch)):
+		listener.cancelDelivery()
+		listener.onTimeout(start, batch...)
+
+		return listener.commitDropped(ctx, ErrProcessingTimeout, batch...)
+
+	case <-listener.inFlightShutdownCh():
+		return listener.awaitInFlightAck(batch...)
+	}
+}
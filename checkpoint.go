@@ -0,0 +1,37 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// checkpoint commits the position of the first message fetched from every partition since the last
+// rebalance or reconnection, before processing it, see WithCheckpointOnAssign. It must be called
+// with the processing lock held.
+func (listener *Listener) checkpoint(ctx context.Context, message kafka.Message) {
+	if !listener.opts.checkpointOnAssign {
+		return
+	}
+
+	partition := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+	if listener.checkpointed[partition] {
+		return
+	}
+
+	if listener.checkpointed == nil {
+		listener.checkpointed = map[TopicPartition]bool{}
+	}
+
+	// Committing the previous offset commits the position of the message, as the commits are of the next offset to fetch.
+	position := kafka.Message{Topic: message.Topic, Partition: message.Partition, Offset: message.Offset - 1}
+
+	if err := listener.commitMessages(ctx, []kafka.Message{position}); err != nil {
+		listener.opts.metricErrors.Inc()
+		listener.log.Errorf(err, "Failed to checkpoint partition (partition = %d, offset = %d)", message.Partition, message.Offset)
+
+		return
+	}
+
+	listener.checkpointed[partition] = true
+}
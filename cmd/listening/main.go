@@ -14,10 +14,6 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-const (
-	maxBytes = 2 << 21
-)
-
 type Config struct {
 	Name         string   `env:"NAME" envDefault:"name"`
 	KafkaUser    string   `env:"KAFKA_USER"`
@@ -33,47 +29,50 @@ func main() {
 
 	signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT)
 
-	opts := kafko.NewOptionsListener().WithReaderFactory(func() kafko.Reader {
-		return kafka.NewReader(kafka.ReaderConfig{
+	opts := kafko.NewOptionsListener().WithName(cfg.Name).WithLogCommittedOffsets().WithReaderFactory(func() kafko.Reader {
+		return kafka.NewReader(kafko.NewReaderConfig(kafka.ReaderConfig{
 			GroupID:     cfg.Name,
 			Topic:       cfg.KafkaTopic,
 			Brokers:     cfg.KafkaBrokers,
 			Dialer:      kafko.NewDialer(cfg.KafkaUser, cfg.KafkaPass),
 			ErrorLogger: log,
 			//Logger:   log,
-			MaxBytes: maxBytes,
-		})
+		}).WithTuning(kafko.DefaultReaderTuning()).Build())
 	})
 
 	consumer := kafko.NewListener(log, opts)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := consumer.RunAsync(ctx)
+
 	go func() {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		<-consumer.Started()
 
-		if err := consumer.Listen(ctx); err != nil {
-			log.Panicf(err, "err := consumer.Listen(context.Background())")
-		}
+		log.Printf("listening")
 	}()
 
 	go func() {
-		<-shutdown
+		sig := <-shutdown
 
 		defer close(shutdown)
 
-		log.Printf("shutting down")
-
-		if err := consumer.Shutdown(context.Background()); err != nil {
-			log.Errorf(err, "err := consumer.Shutdown(context.Background())")
+		if err := consumer.ShutdownWithReason(context.Background(), sig.String()); err != nil {
+			log.Errorf(err, "err := consumer.ShutdownWithReason(context.Background(), sig.String())")
 		}
 	}()
 
-	msgChan, errChan := consumer.MessageAndErrorChannels()
-
-	for msg := range msgChan {
+	if err := consumer.Consume(ctx, func(msg []byte) error {
 		fmt.Printf("msg: %s", string(msg)) //nolint:forbidigo
 
-		errChan <- nil
+		return nil
+	}); err != nil {
+		log.Errorf(err, "err := consumer.Consume(ctx, ...)")
+	}
+
+	if err := <-stopped; err != nil {
+		log.Errorf(err, "err := <-stopped")
 	}
 
 	<-shutdown
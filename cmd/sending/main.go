@@ -61,7 +61,7 @@ func main() { //nolint:funlen
 		writer.AllowAutoTopicCreation = true
 
 		return writer
-	}).WithProcessDroppedMsg(func(_ *kafka.Message, log kafko.Logger) error {
+	}).WithProcessDroppedMsg(func(_ context.Context, _ *kafka.Message, log kafko.Logger) error {
 		return nil
 	})
 
@@ -0,0 +1,48 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// CommitMode tells the Listener when to commit a message, see WithCommitMode.
+type CommitMode int
+
+const (
+	// CommitAfterProcess commits a message once it's processed, so a crash while processing it
+	// redelivers it: at-least-once delivery. It's the default.
+	CommitAfterProcess CommitMode = iota
+	// CommitBeforeProcess commits a message as soon as it's fetched, before its delivery, so a crash
+	// while processing it loses it instead of redelivering it: at-most-once delivery.
+	CommitBeforeProcess
+)
+
+// commitBeforeProcess commits the fetched messages before their delivery if CommitBeforeProcess is set.
+// It returns false if they must not be delivered, as their commit failed and they may be redelivered.
+func (listener *Listener) commitBeforeProcess(ctx context.Context, messages ...kafka.Message) (bool, error) {
+	if listener.opts.commitMode != CommitBeforeProcess {
+		return true, nil
+	}
+
+	listener.addUncommittedMsg(messages...)
+
+	if err := listener.commitUncommittedMessages(ctx); err != nil {
+		listener.opts.metricErrors.Inc()
+
+		if err := listener.handleKafkaError(ctx, err); err != nil {
+			return false, errors.Wrap(err, "err := listener.handleKafkaError(ctx, err) (commitBeforeProcess)")
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// committedBeforeProcess reports whether the processed messages were already committed on fetch,
+// see CommitBeforeProcess, so they mustn't be committed again.
+func (listener *Listener) committedBeforeProcess() bool {
+	return listener.opts.commitMode == CommitBeforeProcess
+}
@@ -0,0 +1,86 @@
+package kafko
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Committer stores the offsets of the processed messages. By default the Listener commits through
+// its Reader, *kafka.Reader included, so a custom Committer is only needed to keep the offsets
+// elsewhere, e.g. in the database of the processed data. The messages given to
+// CommitMessages only have their topic, partition, offset and time set, and the generation the commit
+// is issued in is given by GenerationFromContext, see Generation.
+type Committer interface {
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// CommitFunc is an adapter to allow the use of ordinary functions as Committers.
+type CommitFunc func(ctx context.Context, msgs ...kafka.Message) error
+
+// CommitMessages calls commit(ctx, msgs...).
+func (commit CommitFunc) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return commit(ctx, msgs...)
+}
+
+// committer returns the Committer set by WithCommitter or, if none, the current reader along with
+// whether it's closed. They're read at once, so a concurrent reconnectToKafka can't swap the reader
+// in between, see currentReaderState.
+func (listener *Listener) committer() (Committer, bool) {
+	if listener.opts.committer != nil {
+		return listener.opts.committer, false
+	}
+
+	return listener.currentReaderState()
+}
+
+// commitMessages commits the given messages with the committer and observes the time it took,
+// see WithMetricCommitLatency.
+func (listener *Listener) commitMessages(ctx context.Context, messages []kafka.Message) error {
+	committer, closed := listener.committer()
+
+	// A closed reader can't commit, e.g. once Shutdown force closed it, so don't even try.
+	if closed {
+		return ErrReaderClosed
+	}
+
+	// The committers only need the offsets of the messages.
+	messages = commitOffsets(messages)
+
+	start := time.Now()
+	err := committer.CommitMessages(listener.withGeneration(ctx), messages...)
+
+	latency := time.Since(start)
+	listener.opts.metricCommitLatency.Observe(float64(latency.Milliseconds()))
+
+	if err == nil && listener.opts.secondary != nil {
+		listener.commitSecondary(ctx, messages)
+	}
+
+	return err //nolint:wrapcheck // The callers wrap the error.
+}
+
+// commitSecondary commits the given messages, committed by the committer, with the Committer set by
+// WithSecondaryCommitter. Its failures are only logged and counted, as it's best-effort.
+func (listener *Listener) commitSecondary(ctx context.Context, messages []kafka.Message) {
+	if err := listener.opts.secondary.CommitMessages(listener.withGeneration(ctx), messages...); err != nil {
+		listener.opts.metricSecondaryErrors.Inc()
+		listener.log.Errorf(err, "err := listener.opts.secondary.CommitMessages(ctx, messages...) (messages = %v)", messages)
+	}
+}
+
+// closeReader closes the reader, unless it's already closed, and marks it as closed so the commits
+// through it fail with ErrReaderClosed until reconnectToKafka replaces it.
+func (listener *Listener) closeReader() error {
+	listener.readerMutex.Lock()
+	reader, closed := listener.reader, listener.readerClosed
+	listener.readerClosed = true
+	listener.readerMutex.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	return reader.Close() //nolint:wrapcheck // The callers wrap the error.
+}
@@ -0,0 +1,50 @@
+package kafko
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression codec")
+)
+
+// compressionProbe is the payload checkCompression round-trips through the codec.
+var compressionProbe = []byte("kafko compression probe")
+
+// checkCompression makes sure the codec of the given compression is built into kafka-go and
+// decompresses what it compresses, so a compressed topic doesn't fail once the first batch is read.
+func checkCompression(compression kafka.Compression) error {
+	codec := compression.Codec()
+	if codec == nil {
+		return errors.Wrapf(ErrUnsupportedCompression, "compression = %d", compression)
+	}
+
+	var compressed bytes.Buffer
+
+	writer := codec.NewWriter(&compressed)
+	if _, err := writer.Write(compressionProbe); err != nil {
+		return errors.Wrapf(err, "_, err := writer.Write(compressionProbe) (codec = %s)", codec.Name())
+	}
+
+	if err := writer.Close(); err != nil {
+		return errors.Wrapf(err, "err := writer.Close() (codec = %s)", codec.Name())
+	}
+
+	reader := codec.NewReader(&compressed)
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return errors.Wrapf(err, "decompressed, err := io.ReadAll(reader) (codec = %s)", codec.Name())
+	}
+
+	if !bytes.Equal(decompressed, compressionProbe) {
+		return errors.Wrapf(ErrUnsupportedCompression, "codec = %s doesn't decompress what it compresses", codec.Name())
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package kafko_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/fetch"
+	"github.com/stretchr/testify/assert"
+)
+
+// connReader reads the messages of a kafka.Conn, so they go through the decoding of kafka-go
+// like the messages of a kafka.Reader.
+type connReader struct {
+	conn *kafka.Conn
+}
+
+func (reader connReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return reader.conn.ReadMessage(1 << 20) //nolint:wrapcheck // The Listener handles the error.
+}
+
+func (reader connReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return nil
+}
+
+func (reader connReader) Close() error {
+	return reader.conn.Close() //nolint:wrapcheck // The Listener wraps the error.
+}
+
+// serveFetch answers the requests of a kafka.Conn like a broker holding the given records in
+// a single record batch compressed with the given codec.
+func serveFetch(conn net.Conn, topic string, compression kafka.Compression, records ...protocol.Record) {
+	defer conn.Close()
+
+	for {
+		apiVersion, correlationID, _, request, err := protocol.ReadRequest(conn)
+		if err != nil {
+			// The client closed the connection.
+			return
+		}
+
+		var response protocol.Message
+
+		switch request.(type) {
+		case *apiversions.Request:
+			response = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.Fetch), MinVersion: 2, MaxVersion: 10},
+			}}
+
+		case *fetch.Request:
+			response = &fetch.Response{Topics: []fetch.ResponseTopic{{
+				Topic: topic,
+				Partitions: []fetch.ResponsePartition{{
+					HighWatermark: int64(len(records)),
+					RecordSet: protocol.RecordSet{
+						Version:    2,
+						Attributes: protocol.Attributes(compression),
+						Records:    protocol.NewRecordReader(records...),
+					},
+				}},
+			}}}
+
+		default:
+			return
+		}
+
+		if err := protocol.WriteResponse(conn, apiVersion, correlationID, response); err != nil {
+			return
+		}
+	}
+}
+
+// TestExpectedCompression checks that the common codecs pass the startup check and that the Listener
+// reads a message of a snappy compressed record batch as it was produced.
+func TestExpectedCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, codec := range []kafka.Compression{kafka.Gzip, kafka.Snappy, kafka.Lz4, kafka.Zstd} {
+		assert.NoError(t, kafko.NewOptionsListener().WithExpectedCompression(codec).Validate(), codec.String())
+	}
+
+	value := []byte(`{"id":1,"status":"shipped"}`)
+	client, broker := net.Pipe()
+
+	go serveFetch(broker, "orders", kafka.Snappy, protocol.Record{
+		Offset: 0,
+		Time:   time.Now(),
+		Value:  protocol.NewBytes(value),
+	})
+
+	conn := kafka.NewConnWith(client, kafka.ConnConfig{Topic: "orders"})
+	_, err := conn.Seek(0, kafka.SeekAbsolute|kafka.SeekDontCheck)
+	assert.NoError(t, err)
+
+	opts := kafko.NewOptionsListener().
+		WithExpectedCompression(kafka.Snappy).
+		WithReaderFactory(func() kafko.Reader {
+			return connReader{conn: conn}
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafko.NewListener(log.NewLogger(), opts)
+
+	messages, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, value, messages[0].Value)
+	}
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
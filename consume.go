@@ -0,0 +1,292 @@
+package kafko
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrShuttingDown = errors.New("the listener is shutting down")
+)
+
+// ConsumeN fetches exactly n messages, commits them and returns them, which is handy for batch
+// jobs and tests that can't run Listen forever. If it stops early, it returns the messages fetched
+// so far along with the error. They stay uncommitted, so Shutdown commits them.
+// Like ProcessOnce, it can't run while the Listener is listening, see ErrAlreadyListening.
+// It returns ErrInvalidCount if n is negative, and nothing without fetching if n is zero.
+func (listener *Listener) ConsumeN(ctx context.Context, n int) ([]kafka.Message, error) {
+	if n < 0 {
+		return nil, errors.Wrapf(ErrInvalidCount, "n = %d", n)
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	if !listener.startListening() {
+		return nil, ErrAlreadyListening
+	}
+
+	defer listener.stopListening()
+
+	messages := make([]kafka.Message, 0, n)
+
+	// Add every message as uncommitted while the processing lock is held, so a concurrent
+	// Shutdown commits them.
+	collect := func(ctx context.Context, message kafka.Message) error {
+		listener.observeMessage(message)
+
+		messages = append(messages, message)
+
+		if !listener.committedBeforeProcess() {
+			listener.addUncommittedMsg(message)
+		}
+
+		return nil
+	}
+
+	for len(messages) < n {
+		err := listener.processTick(ctx, collect)
+
+		if errors.Is(err, errExitProcessingLoop) {
+			return messages, ErrShuttingDown
+		}
+
+		if err != nil {
+			return messages, errors.Wrap(err, "err := listener.processTick(ctx, collect)")
+		}
+	}
+
+	if err := listener.doCommitMessage(ctx); err != nil {
+		return messages, errors.Wrap(err, "err := listener.doCommitMessage(ctx)")
+	}
+
+	return messages, nil
+}
+
+// ProcessOnce fetches a single message and processes it with the handler like ListenFunc: it's
+// committed if the handler returns nil, otherwise it's retried or processed as a dropped message. It returns
+// once the message is processed, so tests can drive the Listener one message at a time, e.g. with
+// kafkotest.FakeReader, and assert the outcome without any goroutine or timing. A recoverable Kafka
+// error or a skipped message is handled without calling the handler and returns nil.
+// It's meant for tests and advanced schedulers: it doesn't start the commit loop and can't run while
+// the Listener is listening, see ErrAlreadyListening. A nil handler returns ErrResourceIsNil right away.
+func (listener *Listener) ProcessOnce(ctx context.Context, handler Handler) error {
+	// Fail before fetching a message that would be left unacknowledged.
+	if handler == nil {
+		return errors.Wrap(ErrResourceIsNil, "handler (ProcessOnce)")
+	}
+
+	if !listener.startListening() {
+		return ErrAlreadyListening
+	}
+
+	defer listener.stopListening()
+
+	handler = listener.observed(handler)
+
+	process := func(ctx context.Context, message kafka.Message) error {
+		return listener.processHandler(ctx, message, handler)
+	}
+
+	err := listener.processTick(ctx, process)
+
+	if errors.Is(err, errExitProcessingLoop) {
+		return ErrShuttingDown
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "err := listener.processTick(ctx, process)")
+	}
+
+	return nil
+}
+
+// Consume receives the messages delivered by Listen on the MessageAndErrorChannels, calls handler with
+// each of them and sends its result on the error channel, until the shutdown closes the channels or
+// ctx is done, so the consumers can't forget to acknowledge a message. It runs alongside Listen and
+// returns nil once the channels are closed.
+func (listener *Listener) Consume(ctx context.Context, handler func([]byte) error) error {
+	msgChan, errChan := listener.MessageAndErrorChannels()
+
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				return nil
+			}
+
+			// The handler may outlive the processing timeout and the shutdown meanwhile, see sendResult.
+			if err := listener.sendResult(ctx, errChan, handler(msg)); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (Consume)")
+		}
+	}
+}
+
+// sendResult sends the result of a handler on the error channel, unless the shutdown closed it meanwhile
+// or started while nobody waits for the result, which is then discarded like a late acknowledgement.
+func (listener *Listener) sendResult(ctx context.Context, errChan chan<- error, result error) error {
+	listener.channelsMutex.RLock()
+	defer listener.channelsMutex.RUnlock()
+
+	if listener.channelsClosed {
+		return nil
+	}
+
+	// Prefer the send to the shutdown, e.g. for a message awaited by WithCommitInFlightOnShutdown.
+	select {
+	case errChan <- result:
+		return nil
+	default:
+	}
+
+	// The shutdown closes shuttingDownCh before waiting for channelsMutex to close the channel, so this can't block it.
+	select {
+	case errChan <- result:
+	case <-listener.shuttingDownCh:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (Consume)")
+	}
+
+	return nil
+}
+
+// Next fetches one message and returns it with its acknowledgement function, so the caller drives
+// the consumption at its own pace, e.g. from a request handler. Calling the function with nil commits
+// the message and with an error retries it, see WithRetryTopics, or processes it as a dropped message;
+// only the first call counts.
+// Shutdown waits for the pending acknowledgements, so every message must be acknowledged.
+// Several calls to Next can run at once, but not while the Listener is listening, see ErrAlreadyListening.
+func (listener *Listener) Next(ctx context.Context) (kafka.Message, func(error), error) {
+	if !listener.startNext() {
+		return kafka.Message{}, nil, ErrAlreadyListening
+	}
+
+	defer listener.stopNext()
+
+	// Hold a slot of WithProcessingSemaphore until the message is acknowledged.
+	if err := listener.acquireProcessingSlot(ctx); err != nil {
+		return kafka.Message{}, nil, err
+	}
+
+	for {
+		var (
+			message kafka.Message
+			fetched bool
+		)
+
+		// Track the acknowledgement while the processing lock is held, so a concurrent Shutdown waits for it.
+		fetch := func(ctx context.Context, fetchedMessage kafka.Message) error {
+			listener.observeMessage(fetchedMessage)
+
+			message, fetched = fetchedMessage, true
+			listener.workersWaitGroup.Add(1)
+
+			return nil
+		}
+
+		err := listener.processTick(ctx, fetch)
+
+		if errors.Is(err, errExitProcessingLoop) {
+			listener.releaseProcessingSlot()
+
+			return kafka.Message{}, nil, ErrShuttingDown
+		}
+
+		if err != nil {
+			listener.releaseProcessingSlot()
+
+			return kafka.Message{}, nil, errors.Wrap(err, "err := listener.processTick(ctx, fetch)")
+		}
+
+		// Keep fetching after a recoverable error or a skipped message.
+		if fetched {
+			return message, listener.nextAck(ctx, message), nil
+		}
+	}
+}
+
+// nextAck returns the acknowledgement function of a message returned by Next. Like processOrdered,
+// commit errors are only logged because the message stays uncommitted.
+func (listener *Listener) nextAck(ctx context.Context, message kafka.Message) func(error) {
+	once := &sync.Once{}
+
+	return func(err error) {
+		once.Do(func() {
+			defer listener.workersWaitGroup.Done()
+			defer listener.releaseProcessingSlot()
+
+			processed := []kafka.Message{message}
+
+			if err != nil {
+				listener.log.Errorf(err, "Failed to process message =%v", message)
+
+				// Unless it was retried or the dropped message handler took it over, the message is skipped.
+				processed = listener.failMessages(ctx, processed, err)
+			}
+
+			if len(processed) == 0 || listener.committedBeforeProcess() {
+				return
+			}
+
+			listener.addUncommittedMsg(processed...)
+
+			if listener.opts.commitOnShutdownOnly {
+				return
+			}
+
+			if err := listener.commitUncommittedMessages(ctx); err != nil {
+				listener.log.Errorf(err, "err := listener.commitUncommittedMessages(ctx) (Next)")
+			}
+		})
+	}
+}
+
+// startListening marks the Listener as listening, unless it already is or Next is running, as two
+// fetch loops would race on the same reader and channels. It returns whether it did.
+func (listener *Listener) startListening() bool {
+	if !atomic.CompareAndSwapInt32(&listener.listening, 0, 1) {
+		return false
+	}
+
+	// Next counts itself before checking listening, so one of them always backs off, see startNext.
+	if atomic.LoadInt32(&listener.nextCalls) > 0 {
+		listener.stopListening()
+
+		return false
+	}
+
+	return true
+}
+
+// stopListening clears the mark set by startListening.
+func (listener *Listener) stopListening() {
+	atomic.StoreInt32(&listener.listening, 0)
+}
+
+// startNext counts a call to Next, unless the Listener is listening. It returns whether it did.
+// The calls to Next don't exclude each other, as they take turns on the processing lock.
+func (listener *Listener) startNext() bool {
+	atomic.AddInt32(&listener.nextCalls, 1)
+
+	if atomic.LoadInt32(&listener.listening) == 1 {
+		listener.stopNext()
+
+		return false
+	}
+
+	return true
+}
+
+// stopNext uncounts a call to Next counted by startNext.
+func (listener *Listener) stopNext() {
+	atomic.AddInt32(&listener.nextCalls, -1)
+}
@@ -0,0 +1,72 @@
+package kafko
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// messageDeadline returns the time by which the message must be processed, see WithDeadlineFromMessageTime.
+// The messages without time, e.g. produced by old clients, have no deadline.
+func (listener *Listener) messageDeadline(message kafka.Message) (time.Time, bool) {
+	if listener.opts.deadlineFromMessageTime <= 0 || message.Time.IsZero() {
+		return time.Time{}, false
+	}
+
+	return message.Time.Add(listener.opts.deadlineFromMessageTime), true
+}
+
+// untilDeadline returns the time left until the deadline of the message, if it has one.
+func (listener *Listener) untilDeadline(message kafka.Message) (time.Duration, bool) {
+	deadline, ok := listener.messageDeadline(message)
+	if !ok {
+		return 0, false
+	}
+
+	return deadline.Sub(listener.opts.now()), true
+}
+
+// deadlineExceeded returns the reason to drop the message past its deadline. It's a processing
+// timeout, so the message is committed once the dropped message handler succeeds, see DropSemantics.
+func (listener *Listener) deadlineExceeded(message kafka.Message) error {
+	listener.log.Printf("Dropping message past its deadline (partition = %d, offset = %d, time = %v)",
+		message.Partition, message.Offset, message.Time)
+
+	return errors.Wrapf(ErrProcessingTimeout, "deadline exceeded (time = %v, deadline = %v)",
+		message.Time, listener.opts.deadlineFromMessageTime)
+}
+
+// withinDeadline returns a Handler that drops the messages past their deadline and calls handler with
+// the rest, with a context expiring at their deadline.
+func (listener *Listener) withinDeadline(handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		remaining, ok := listener.untilDeadline(message)
+		if !ok {
+			return handler(ctx, message)
+		}
+
+		if remaining <= 0 {
+			return listener.deadlineExceeded(message)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, remaining)
+		defer cancel()
+
+		return handler(ctx, message)
+	}
+}
+
+// deliverWithinDeadline returns a deliver function for processTick that drops the messages past their
+// deadline, like ListenFunc, and delivers the rest of the messages with deliver. Their processing
+// timeout is the time left until their deadline, see processingTimeout.
+func (listener *Listener) deliverWithinDeadline(deliver Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if remaining, ok := listener.untilDeadline(message); ok && remaining <= 0 {
+			return listener.commitDropped(ctx, listener.deadlineExceeded(message), message)
+		}
+
+		return deliver(ctx, message)
+	}
+}
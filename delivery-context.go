@@ -0,0 +1,40 @@
+package kafko
+
+import (
+	"context"
+)
+
+// deliveryContext is the context of the message, or batch, being delivered through the channels.
+type deliveryContext struct {
+	ctx    context.Context //nolint:containedctx // It's handed to the consumer by DeliveryContext.
+	cancel context.CancelFunc
+}
+
+// DeliveryContext returns the context of the message, or batch, received last from the channels. It's
+// canceled as soon as the processing times out and the message is dropped, so the consumer can abort the
+// work whose result would be ignored, and once the message is acknowledged. Call it right after receiving
+// the message. It returns context.Background() before the first delivery.
+func (listener *Listener) DeliveryContext() context.Context {
+	delivery, ok := listener.delivery.Load().(*deliveryContext)
+	if !ok {
+		return context.Background()
+	}
+
+	return delivery.ctx
+}
+
+// startDelivery creates the context of the next delivery, see DeliveryContext, and returns the
+// function canceling it once the delivery is resolved.
+func (listener *Listener) startDelivery(ctx context.Context) context.CancelFunc {
+	deliveryCtx, cancel := context.WithCancel(ctx)
+	listener.delivery.Store(&deliveryContext{ctx: deliveryCtx, cancel: cancel})
+
+	return cancel
+}
+
+// cancelDelivery cancels the context of the current delivery, e.g. when its processing times out.
+func (listener *Listener) cancelDelivery() {
+	if delivery, ok := listener.delivery.Load().(*deliveryContext); ok {
+		delivery.cancel()
+	}
+}
@@ -0,0 +1,116 @@
+package kafko
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// The provenance headers written by NewDLQHandler and read by NewReplayListener.
+const (
+	DLQHeaderTopic     = "x-dlq-original-topic"
+	DLQHeaderPartition = "x-dlq-original-partition"
+	DLQHeaderOffset    = "x-dlq-original-offset"
+)
+
+var (
+	ErrNoProvenance = errors.New("the message has no provenance headers")
+)
+
+// NewDLQHandler returns a dropped message handler writing the dropped messages to the given dead-letter
+// topic, with their key, value and headers, along with provenance headers recording their original topic,
+// partition and offset, and the headers of WithRepublishHeaders. Leave topic empty if the writer sets it.
+// The messages are replayed by NewReplayListener.
+func NewDLQHandler(writer Writer, topic string) ProcessDroppedMsgHandler {
+	return func(ctx context.Context, msg *kafka.Message, log Logger) error {
+		headers := make([]kafka.Header, 0, len(msg.Headers)+3)
+		headers = append(headers, msg.Headers...)
+		headers = append(headers,
+			kafka.Header{Key: DLQHeaderTopic, Value: []byte(msg.Topic)},
+			kafka.Header{Key: DLQHeaderPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+			kafka.Header{Key: DLQHeaderOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		)
+
+		dead := kafka.Message{
+			Topic:   topic,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: mergeHeaders(headers, republishHeaders(ctx)(*msg, DropReason(ctx))),
+		}
+
+		if err := writer.WriteMessages(ctx, dead); err != nil {
+			return errors.Wrapf(err, "err := writer.WriteMessages(ctx, dead) (topic = %s)", topic)
+		}
+
+		return nil
+	}
+}
+
+// restoreProvenance returns the message of a dead-letter topic as it was originally consumed: its
+// original topic, partition and offset are restored and the provenance headers removed.
+func restoreProvenance(message kafka.Message) (kafka.Message, error) {
+	topic, foundTopic := headerValue(message, DLQHeaderTopic)
+	partition, foundPartition := headerValue(message, DLQHeaderPartition)
+	offset, foundOffset := headerValue(message, DLQHeaderOffset)
+
+	if !foundTopic || !foundPartition || !foundOffset {
+		return kafka.Message{}, errors.Wrapf(ErrNoProvenance, "(restoreProvenance) offset = %d", message.Offset)
+	}
+
+	restored := message
+	restored.Topic = topic
+	restored.Headers = nil
+
+	var err error
+
+	if restored.Partition, err = strconv.Atoi(partition); err != nil {
+		return kafka.Message{}, errors.Wrapf(err, "restored.Partition, err = strconv.Atoi(partition) (partition = %q)", partition)
+	}
+
+	if restored.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+		return kafka.Message{}, errors.Wrapf(err, "restored.Offset, err = strconv.ParseInt(offset, 10, 64) (offset = %q)", offset)
+	}
+
+	for _, header := range message.Headers {
+		switch header.Key {
+		case DLQHeaderTopic, DLQHeaderPartition, DLQHeaderOffset:
+		default:
+			restored.Headers = append(restored.Headers, header)
+		}
+	}
+
+	return restored, nil
+}
+
+// ReplayListener is a Listener reading a dead-letter topic written by NewDLQHandler, see NewReplayListener.
+type ReplayListener struct {
+	*Listener
+
+	handler Handler
+}
+
+// NewReplayListener creates a Listener reading a dead-letter topic written by NewDLQHandler, whose reader is
+// set by opts like for NewListener, and handing every message to the handler for reprocessing as it was
+// originally consumed: its original topic, partition and offset are restored from the provenance headers,
+// which are removed. Like for ListenFunc, the message is committed to the dead-letter topic if the handler
+// returns nil. The messages without provenance headers are processed as dropped messages.
+func NewReplayListener(log Logger, handler Handler, opts ...*OptionsListener) *ReplayListener {
+	return &ReplayListener{
+		Listener: NewListener(log, opts...),
+		handler:  handler,
+	}
+}
+
+// Listen replays the dead-letter topic until the Listener stops, see ListenFunc.
+func (replay *ReplayListener) Listen(ctx context.Context) error {
+	return replay.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		restored, err := restoreProvenance(message)
+		if err != nil {
+			return err
+		}
+
+		return replay.handler(ctx, restored)
+	})
+}
@@ -0,0 +1,148 @@
+package kafko_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/kafkotest"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestDLQRoundTrip checks that a message dropped to a dead-letter topic is replayed as it was
+// originally consumed.
+func TestDLQRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	original := kafka.Message{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("test message"),
+		Headers:   []kafka.Header{{Key: "x-tenant", Value: []byte("tenant")}},
+	}
+
+	dead := make(chan kafka.Message, 1)
+
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		msgs, _ := args.Get(1).([]kafka.Message)
+		dead <- msgs[0]
+	}).Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The failing message is written to the dead-letter topic.
+	consumer := kafko.NewListener(log.NewLogger(), kafko.NewOptionsListener().
+		WithProcessDroppedMsg(kafko.NewDLQHandler(writer, "orders-dlq")).
+		WithReaderFactory(func() kafko.Reader {
+			return kafkotest.NewFakeReader(original)
+		}))
+
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+			return errors.New("failed to process the message")
+		}))
+	}()
+
+	deadMessage := <-dead
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+	assert.Equal(t, "orders-dlq", deadMessage.Topic)
+
+	// The dead-letter topic is replayed with the original topic, partition and offset.
+	deadMessage.Partition, deadMessage.Offset = 0, 7
+	dlqReader := kafkotest.NewFakeReader(deadMessage, kafka.Message{Topic: "orders-dlq", Offset: 8})
+	replayed := make(chan kafka.Message, 1)
+	dropped := make(chan int64, 1)
+
+	replay := kafko.NewReplayListener(log.NewLogger(), func(ctx context.Context, message kafka.Message) error {
+		replayed <- message
+
+		return nil
+	}, kafko.NewOptionsListener().
+		WithReaderFactory(func() kafko.Reader {
+			return dlqReader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log kafko.Logger) error {
+			dropped <- msg.Offset
+
+			return nil
+		}))
+
+	go func() {
+		assert.Equal(t, original, <-replayed)
+
+		// The message without provenance headers is dropped.
+		assert.Equal(t, int64(8), <-dropped)
+		assert.NoError(t, replay.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, replay.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Topic: "orders-dlq", Offset: 7}}, dlqReader.Committed())
+}
+
+// TestRepublishHeaders checks that the headers of WithRepublishHeaders are added to the dead-letter
+// messages, without overwriting the original headers, along with the reason of the drop.
+func TestRepublishHeaders(t *testing.T) {
+	t.Parallel()
+
+	original := kafka.Message{
+		Topic:   "orders",
+		Value:   []byte("test message"),
+		Headers: []kafka.Header{{Key: "x-tenant", Value: []byte("tenant")}},
+	}
+
+	dead := make(chan kafka.Message, 1)
+
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		msgs, _ := args.Get(1).([]kafka.Message)
+		dead <- msgs[0]
+	}).Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafko.NewListener(log.NewLogger(), kafko.NewOptionsListener().
+		WithProcessDroppedMsg(kafko.NewDLQHandler(writer, "orders-dlq")).
+		WithRepublishHeaders(func(original kafka.Message, reason error) []kafka.Header {
+			return []kafka.Header{
+				{Key: "x-tenant", Value: []byte("overwritten")},
+				{Key: "x-reason", Value: []byte(reason.Error())},
+			}
+		}).
+		WithReaderFactory(func() kafko.Reader {
+			return kafkotest.NewFakeReader(original)
+		}))
+
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+			return errors.New("failed to process the message")
+		}))
+	}()
+
+	deadMessage := <-dead
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+	assert.Equal(t, "tenant", headerValue(deadMessage, "x-tenant"))
+	assert.Equal(t, "failed to process the message", headerValue(deadMessage, "x-reason"))
+	assert.Equal(t, "orders", headerValue(deadMessage, kafko.DLQHeaderTopic))
+}
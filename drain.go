@@ -0,0 +1,119 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Drain stops fetching new messages, waits for the messages in flight to be acknowledged and commits
+// every processed message, but unlike Shutdown it keeps the reader and the channels open, so the
+// Listener goes on once Resume is called, e.g. after a downstream migration. Meanwhile Listen and
+// ListenFunc keep running without fetching, and Next and ConsumeN wait for Resume. A fetch waiting
+// for a message is interrupted, but a batch being filled is waited for. If ctx is done first, the
+// Listener stays drained and the error is returned.
+func (listener *Listener) Drain(ctx context.Context) error {
+	listener.drainMutex.Lock()
+
+	if listener.resumed == nil {
+		listener.resumed = make(chan struct{})
+	}
+
+	stopFetch := listener.stopFetch
+	listener.drainMutex.Unlock()
+
+	// Interrupt the fetch waiting for a message, the message is fetched again once resumed.
+	if stopFetch != nil {
+		stopFetch()
+	}
+
+	// The processing lock is held while a message is in flight and the handlers of WithOrderedConcurrency
+	// and the acknowledgements of Next are tracked by workersWaitGroup.
+	idle := make(chan struct{})
+
+	listener.spawn(func() {
+		defer close(idle)
+
+		listener.processing.Lock()
+		listener.processing.Unlock() //nolint:staticcheck // The lock is only taken to wait for the processing.
+
+		listener.workersWaitGroup.Wait()
+	})
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (Drain)")
+	}
+
+	if err := listener.commitUncommittedMessages(ctx); err != nil {
+		return errors.Wrap(err, "err := listener.commitUncommittedMessages(ctx) (Drain)")
+	}
+
+	return nil
+}
+
+// Resume lets the Listener fetch again after Drain. It does nothing if the Listener isn't drained.
+func (listener *Listener) Resume() {
+	listener.drainMutex.Lock()
+	defer listener.drainMutex.Unlock()
+
+	if listener.resumed != nil {
+		close(listener.resumed)
+		listener.resumed = nil
+	}
+}
+
+// draining reports whether Drain was called since the last Resume.
+func (listener *Listener) draining() bool {
+	listener.drainMutex.Lock()
+	defer listener.drainMutex.Unlock()
+
+	return listener.resumed != nil
+}
+
+// awaitResume waits for Resume if the Listener is drained. It returns errExitProcessingLoop if the
+// shutdown starts meanwhile.
+func (listener *Listener) awaitResume(ctx context.Context) error {
+	listener.drainMutex.Lock()
+	resumed := listener.resumed
+	listener.drainMutex.Unlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+
+	case <-listener.shuttingDownCh:
+		return errExitProcessingLoop
+
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (awaitResume)")
+	}
+}
+
+// fetchContext returns the context of a fetch, canceled by Drain, and the function releasing it.
+// It's already canceled if the Listener is drained. It must be called with the processing lock held.
+func (listener *Listener) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	fetchCtx, cancel := context.WithCancel(ctx)
+
+	listener.drainMutex.Lock()
+	listener.stopFetch = cancel
+	drained := listener.resumed != nil
+	listener.drainMutex.Unlock()
+
+	if drained {
+		cancel()
+	}
+
+	return fetchCtx, func() {
+		listener.drainMutex.Lock()
+		listener.stopFetch = nil
+		listener.drainMutex.Unlock()
+
+		cancel()
+	}
+}
@@ -0,0 +1,38 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// Enricher completes or transforms a message in place, e.g. its value or headers, before it's
+// delivered, see WithEnricher.
+type Enricher func(ctx context.Context, message *kafka.Message) error
+
+// enrich returns a Handler that enriches the messages before handing them to handler. A failed
+// enrichment fails the handler, so the message is processed as a dropped message.
+func enrich(enricher Enricher, handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if err := enricher(ctx, &message); err != nil {
+			return errors.Wrap(err, "err := enricher(ctx, &message)")
+		}
+
+		return handler(ctx, message)
+	}
+}
+
+// deliverEnriched returns a deliver function for processTick that enriches the messages before
+// delivering them with deliver, and processes the ones failing the enrichment as dropped messages.
+func (listener *Listener) deliverEnriched(deliver Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if err := listener.opts.enricher(ctx, &message); err != nil {
+			listener.log.Errorf(err, "Failed to enrich message =%v", message)
+
+			return listener.commitDropped(ctx, err, message)
+		}
+
+		return deliver(ctx, message)
+	}
+}
@@ -0,0 +1,39 @@
+package kafko
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type generationKey struct{}
+
+// Generation returns the generation of the consumer group membership as of the last fetch, so a sink
+// can fence the writes of a zombie consumer, see GenerationFromContext. ok is false before the first
+// fetch or if the reader doesn't implement GenerationReader.
+func (listener *Listener) Generation() (generationID int32, ok bool) {
+	generation := atomic.LoadInt64(&listener.generation)
+	if generation < 0 {
+		return 0, false
+	}
+
+	return int32(generation), true
+}
+
+// GenerationFromContext returns the generation the message handled with ctx was fetched in, or the
+// commit with ctx was issued in, see Generation. ctx is the one given to the Handler, the dropped
+// message handler or the Committer, or returned by DeliveryContext.
+func GenerationFromContext(ctx context.Context) (generationID int32, ok bool) {
+	generationID, ok = ctx.Value(generationKey{}).(int32)
+
+	return generationID, ok
+}
+
+// withGeneration returns ctx carrying the current generation, if known, see GenerationFromContext.
+func (listener *Listener) withGeneration(ctx context.Context) context.Context {
+	generationID, ok := listener.Generation()
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, generationKey{}, generationID)
+}
@@ -0,0 +1,39 @@
+package kafko
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// limitHandlerTime returns a Handler that calls handler with a context canceled once the timeout set by
+// WithHandlerTimeout expired. If it did, the timeout is counted and reported to the timeout hook, and the
+// error returned by the handler, if any, is a processing timeout, so the message is committed once the
+// dropped message handler succeeds, see DropSemantics. The handler must return once its context is done,
+// the Listener can't stop it otherwise.
+func (listener *Listener) limitHandlerTime(handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		start := time.Now()
+
+		handlerCtx, cancel := context.WithTimeout(ctx, listener.opts.handlerTimeout)
+		defer cancel()
+
+		err := handler(handlerCtx, message)
+
+		// The handler wasn't cut short by its own timeout, e.g. ctx is done.
+		if ctx.Err() != nil || !errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+
+		listener.opts.metricHandlerTimeouts.Inc()
+		listener.onTimeout(start, message)
+
+		if err != nil {
+			return errors.Wrapf(ErrProcessingTimeout, "handler timeout (timeout = %v): %v", listener.opts.handlerTimeout, err)
+		}
+
+		return nil
+	}
+}
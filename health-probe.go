@@ -0,0 +1,55 @@
+package kafko
+
+import (
+	"time"
+)
+
+// slowFetchesToSwap is the number of consecutive slow fetches that make the health probe swap the reader.
+const slowFetchesToSwap = 3
+
+// lagReader is implemented by *kafka.Reader.
+type lagReader interface {
+	Lag() int64
+}
+
+// probeFetch tells whether the reader must be swapped after a fetch that took the given latency, see
+// WithReaderHealthProbe. A fetch is slow if it took longer than the maximum fetch latency while the reader
+// lags, as waiting for new messages on a quiet topic is fine. It must be called with the processing lock held.
+func (listener *Listener) probeFetch(latency time.Duration) bool {
+	if listener.opts.maxFetchLatency <= 0 {
+		return false
+	}
+
+	if latency <= listener.opts.maxFetchLatency || !listener.readerLags() {
+		listener.slowFetches = 0
+
+		return false
+	}
+
+	listener.slowFetches++
+	if listener.slowFetches < slowFetchesToSwap {
+		return false
+	}
+
+	listener.slowFetches = 0
+
+	return true
+}
+
+// readerLags tells whether there are messages left to fetch. The readers unable to tell are
+// assumed to lag.
+func (listener *Listener) readerLags() bool {
+	if reader, ok := listener.currentReader().(lagReader); ok {
+		return reader.Lag() > 0
+	}
+
+	return true
+}
+
+// swapSlowReader replaces the reader whose fetches are persistently slow, see WithReaderHealthProbe.
+func (listener *Listener) swapSlowReader(latency time.Duration) {
+	listener.log.Printf("Fetches slower than %v (latency = %v), swapping the reader", listener.opts.maxFetchLatency, latency)
+
+	listener.opts.metricHealthProbeSwaps.Inc()
+	listener.reconnectToKafka()
+}
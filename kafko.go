@@ -13,6 +13,14 @@ const (
 	commitInterval    = time.Duration(30) * time.Second
 	reconnectInterval = time.Duration(10) * time.Second
 	processingTimeout = time.Duration(5) * time.Second
+	batchMaxWait      = time.Duration(1) * time.Second
+	shutdownTimeout   = time.Duration(30) * time.Second
+	commitTimeout     = time.Duration(5) * time.Second
+	throttleInterval  = time.Duration(1) * time.Second
+	throttleDelay     = time.Duration(100) * time.Millisecond
+
+	// maxRecommitJitter caps WithRecommitJitter, so a commit attempt waits at least half the interval.
+	maxRecommitJitter = 0.5
 )
 
 func NewDialer(username, password string) *kafka.Dialer {
@@ -0,0 +1,199 @@
+// Package kafkotest provides test doubles for the kafko package.
+package kafkotest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/m3co/kafko"
+	"github.com/segmentio/kafka-go"
+)
+
+// FakeReader is an in-memory kafko.Reader. It returns the preloaded messages in order, records
+// the committed messages and returns the injected errors before fetching or committing.
+// Use a temporary kafka.Error, like kafka.NetworkException, to exercise the reconnection path.
+// It's safe for concurrent use.
+type FakeReader struct {
+	mutex     sync.Locker
+	available chan struct{} // Signaled when messages are pushed.
+
+	messages     []kafka.Message
+	fetchErrors  []error
+	commitErrors []error
+	committed    []kafka.Message
+	imported     map[int]int64 // Offsets set by SetCommittedOffsets.
+	closed       bool
+
+	generationID int32
+	assignments  []kafko.TopicPartition
+}
+
+// NewFakeReader creates a FakeReader preloaded with the given messages.
+func NewFakeReader(messages ...kafka.Message) *FakeReader {
+	return &FakeReader{
+		mutex:     &sync.Mutex{},
+		available: make(chan struct{}, 1),
+		messages:  messages,
+	}
+}
+
+// Push appends messages to the ones to fetch.
+func (reader *FakeReader) Push(messages ...kafka.Message) *FakeReader {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.messages = append(reader.messages, messages...)
+
+	select {
+	case reader.available <- struct{}{}:
+	default:
+	}
+
+	return reader
+}
+
+// FailFetch makes the next calls to FetchMessage return the given errors, one per call.
+func (reader *FakeReader) FailFetch(errs ...error) *FakeReader {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.fetchErrors = append(reader.fetchErrors, errs...)
+
+	return reader
+}
+
+// FailCommit makes the next calls to CommitMessages return the given errors, one per call.
+func (reader *FakeReader) FailCommit(errs ...error) *FakeReader {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.commitErrors = append(reader.commitErrors, errs...)
+
+	return reader
+}
+
+// FetchMessage returns the next injected error or message. Once there are none left, it blocks
+// until messages are pushed or the ctx is done.
+func (reader *FakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		reader.mutex.Lock()
+
+		if len(reader.fetchErrors) > 0 {
+			err := reader.fetchErrors[0]
+			reader.fetchErrors = reader.fetchErrors[1:]
+			reader.mutex.Unlock()
+
+			return kafka.Message{}, err
+		}
+
+		if len(reader.messages) > 0 {
+			message := reader.messages[0]
+			reader.messages = reader.messages[1:]
+			reader.mutex.Unlock()
+
+			return message, nil
+		}
+
+		reader.mutex.Unlock()
+
+		select {
+		case <-reader.available:
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// CommitMessages returns the next injected error or records the messages as committed.
+func (reader *FakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	if len(reader.commitErrors) > 0 {
+		err := reader.commitErrors[0]
+		reader.commitErrors = reader.commitErrors[1:]
+
+		return err
+	}
+
+	reader.committed = append(reader.committed, msgs...)
+
+	return nil
+}
+
+// Close marks the reader as closed.
+func (reader *FakeReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.closed = true
+
+	return nil
+}
+
+// Committed returns the committed messages in commit order.
+func (reader *FakeReader) Committed() []kafka.Message {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	return append([]kafka.Message{}, reader.committed...)
+}
+
+// CommittedOffsets returns the offset the consumption resumes from on every partition, i.e.
+// the highest committed offset plus one like Kafka does. It implements kafko.OffsetReader.
+func (reader *FakeReader) CommittedOffsets(ctx context.Context) (map[int]int64, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	offsets := map[int]int64{}
+	for partition, offset := range reader.imported {
+		offsets[partition] = offset
+	}
+
+	for _, message := range reader.committed {
+		if offset, ok := offsets[message.Partition]; !ok || message.Offset+1 > offset {
+			offsets[message.Partition] = message.Offset + 1
+		}
+	}
+
+	return offsets, nil
+}
+
+// SetCommittedOffsets sets the offsets CommittedOffsets starts from, raised by the messages committed
+// later on. It implements kafko.OffsetWriter, but the messages to fetch are kept.
+func (reader *FakeReader) SetCommittedOffsets(ctx context.Context, offsets map[int]int64) error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.imported = offsets
+
+	return nil
+}
+
+// Rebalance simulates a rebalance of the consumer group: the next fetches belong to the given
+// generation with the given partitions assigned.
+func (reader *FakeReader) Rebalance(generationID int32, assignments ...kafko.TopicPartition) *FakeReader {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	reader.generationID = generationID
+	reader.assignments = assignments
+
+	return reader
+}
+
+// Generation returns the generation set by Rebalance. It implements kafko.GenerationReader.
+func (reader *FakeReader) Generation() (int32, []kafko.TopicPartition) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	return reader.generationID, reader.assignments
+}
+
+// Closed tells whether Close was called.
+func (reader *FakeReader) Closed() bool {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	return reader.closed
+}
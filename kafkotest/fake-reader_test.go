@@ -0,0 +1,50 @@
+package kafkotest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/kafkotest"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFakeReader checks that the listener reconnects after an injected temporary error and
+// that the FakeReader records the committed messages.
+func TestFakeReader(t *testing.T) {
+	t.Parallel()
+
+	messages := []kafka.Message{{Partition: 0, Offset: 4}, {Partition: 1, Offset: 7}}
+	errorAtFetchMessage := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(messages...).FailFetch(&errorAtFetchMessage)
+	reconnections := 0
+
+	opts := kafko.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() kafko.Reader {
+			reconnections++
+
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafko.NewListener(log.NewLogger(), opts)
+
+	consumed, err := consumer.ConsumeN(ctx, len(messages))
+	assert.NoError(t, err)
+	assert.Equal(t, messages, consumed)
+	assert.Equal(t, 2, reconnections)
+	assert.Equal(t, messages, reader.Committed())
+
+	offsets, err := consumer.CommittedOffsets(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 5, 1: 8}, offsets)
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+	assert.True(t, reader.Closed())
+}
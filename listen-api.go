@@ -2,22 +2,199 @@ package kafko
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
 )
 
 // Listen starts the Listener to fetch and process messages from the Kafka topic.
 // It also starts the commit loop and handles message errors.
-func (listener *Listener) Listen(ctxIn context.Context) error { //nolint:cyclop
+// It returns ErrShuttingDown right away if the Listener was already shut down.
+func (listener *Listener) Listen(ctx context.Context) error {
+	_, err := listener.ListenWithReason(ctx)
+
+	return err
+}
+
+// ListenWithReason starts the Listener like Listen and also returns why it stopped, so callers
+// can tell a clean shutdown from a canceled context or an unexpected exit.
+func (listener *Listener) ListenWithReason(ctx context.Context) (StopReason, error) {
+	deliver := Handler(listener.processMessageAndError)
+
+	if listener.opts.tombstoneHandler != nil {
+		deliver = listener.deliverTombstones(deliver)
+	}
+
+	deliver = listener.observed(deliver)
+
+	if listener.opts.enricher != nil {
+		deliver = listener.deliverEnriched(deliver)
+	}
+
+	if listener.opts.maxMessageSize > 0 {
+		deliver = listener.deliverLimited(deliver)
+	}
+
+	if listener.opts.deadlineFromMessageTime > 0 {
+		deliver = listener.deliverWithinDeadline(deliver)
+	}
+
+	processTick := func(ctx context.Context) error {
+		return listener.processTick(ctx, deliver)
+	}
+
+	if listener.opts.batchMaxSize > 0 || listener.opts.batchMaxKeys > 0 {
+		processTick = listener.processBatchTick
+	}
+
+	return listener.listen(ctx, listener.limitProcessing(processTick))
+}
+
+// RunAsync starts the Listener like Listen in a goroutine of its own and returns a channel yielding
+// the error Listen returned, nil after a clean shutdown, once it stopped. The channel is then closed,
+// so it's also the join point of the shutdown.
+func (listener *Listener) RunAsync(ctx context.Context) <-chan error {
+	errs := make(chan error, 1)
+
+	listener.spawn(func() {
+		defer close(errs)
+
+		errs <- listener.Listen(ctx)
+	})
+
+	return errs
+}
+
+// ListenFunc starts the Listener like Listen but, instead of delivering the messages through
+// the channels, it calls the handler with every message. The message is committed if the
+// handler returns nil, otherwise it's retried if WithRetryTopics is set, like a Nack, or
+// processed as a dropped message.
+// If WithHeaderRouter is set, the handler only receives the messages without a matching route and may be nil,
+// otherwise a nil handler returns ErrResourceIsNil right away.
+// If WithHeadersOnly is set, the handlers receive the messages without their value.
+// If WithTombstoneHandler is set, the handler doesn't receive the tombstones.
+// If WithEnricher is set, the messages are enriched before being routed and handled.
+// If WithDeadlineFromMessageTime is set, the handler's context expires at the deadline of the message.
+// If WithHandlerTimeout is set, the handler's context is canceled once the handler timeout expired.
+// If WithOrderedConcurrency is set, the handler is called concurrently.
+func (listener *Listener) ListenFunc(ctx context.Context, handler Handler) error {
+	// Fail at startup rather than at the first message.
+	if handler == nil && listener.opts.routes == nil {
+		return errors.Wrap(ErrResourceIsNil, "handler (ListenFunc without WithHeaderRouter)")
+	}
+
+	if listener.opts.routes != nil {
+		handler = routeByHeader(listener.opts.routingHeader, listener.opts.routes, handler)
+	}
+
+	// Strip the value once every check of the value is done.
+	if listener.opts.headersOnly {
+		handler = withoutValue(handler)
+	}
+
+	if listener.opts.tombstoneHandler != nil {
+		handler = routeTombstones(listener.opts.tombstoneHandler, handler)
+	}
+
+	handler = listener.observed(handler)
+
+	if listener.opts.enricher != nil {
+		handler = enrich(listener.opts.enricher, handler)
+	}
+
+	if listener.opts.maxMessageSize > 0 {
+		handler = listener.limitSize(handler)
+	}
+
+	if listener.opts.deadlineFromMessageTime > 0 {
+		handler = listener.withinDeadline(handler)
+	}
+
+	if listener.opts.handlerTimeout > 0 {
+		handler = listener.limitHandlerTime(handler)
+	}
+
+	processTick := func(ctx context.Context) error {
+		return listener.processTick(ctx, func(ctx context.Context, message kafka.Message) error {
+			return listener.processHandler(ctx, message, handler)
+		})
+	}
+
+	processTick = listener.limitProcessing(processTick)
+
+	// The workers of WithOrderedConcurrency hold their slot of WithProcessingSemaphore themselves.
+	if listener.opts.orderedConcurrency > 1 {
+		processTick = listener.orderedTick(handler, listener.opts.orderedConcurrency)
+	}
+
+	_, err := listener.listen(ctx, processTick)
+
+	return err
+}
+
+// listen runs the commit loop and calls processTick until the Listener stops, and returns why it stopped.
+func (listener *Listener) listen(ctxIn context.Context, processTick func(ctx context.Context) error) (StopReason, error) { //nolint:cyclop
+	if !listener.startListening() {
+		return StopError, ErrAlreadyListening
+	}
+
+	defer listener.stopListening()
+
+	// Don't start the commit loop against a listener that was shut down or a context that is done.
+	select {
+	case <-listener.shuttingDownCh:
+		return StopError, ErrShuttingDown
+	default:
+	}
+
+	if err := ctxIn.Err(); err != nil {
+		return StopError, errors.Wrap(err, "err := ctxIn.Err() (Listen)")
+	}
+
 	ctx, cancel := context.WithCancel(ctxIn)
 
-	go func() {
-		<-listener.shuttingDownCh
-		cancel()
-	}()
+	// Stop the goroutines of this run before clearing listening, so the next Listen doesn't race
+	// with them on the same reader, see ErrAlreadyListening.
+	running := &sync.WaitGroup{}
+
+	defer running.Wait()
+	defer cancel()
+
+	running.Add(1)
+
+	listener.spawn(func() {
+		defer running.Done()
+
+		select {
+		case <-listener.shuttingDownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	if listener.opts.logCommittedOffsets {
+		listener.logCommittedOffsets(ctx)
+	}
+
+	// Start the commit loop in a separate goroutine, unless it's disabled.
+	if !listener.opts.withoutRecommitLoop {
+		listener.commitLoopWaitGroup.Add(1)
+		running.Add(1)
+
+		listener.spawn(func() {
+			defer running.Done()
+			defer listener.commitLoopWaitGroup.Done()
 
-	// Start the commit loop in a separate goroutine.
-	go listener.runCommitLoop(ctx)
+			listener.runCommitLoop(ctx)
+		})
+	}
+
+	// Fetch the messages ahead of the processing, if enabled.
+	if listener.opts.prefetch > 0 {
+		listener.startPrefetch(ctx, listener.opts.prefetch, running)
+	}
 
 	// Continuously fetch and process messages.
 	for {
@@ -26,74 +203,231 @@ func (listener *Listener) Listen(ctxIn context.Context) error { //nolint:cyclop
 			closed := !isOpen
 			if closed {
 				// If the listener.messageChan has been closed, exit the loop.
-				return nil
+				return StopChannelClosed, nil
 			}
 
 		case <-ctx.Done():
 			// If the context is done, check for an error and return it.
 			if err := ctx.Err(); err != nil {
 				if errors.Is(err, context.Canceled) {
-					return nil
+					return listener.canceledReason(), nil
 				}
 
-				return errors.Wrap(err, "err := ctx.Err() (ctx.Done()) (Listen)")
+				return StopError, errors.Wrap(err, "err := ctx.Err() (ctx.Done()) (Listen)")
 			}
 
 		case <-listener.shuttingDownCh:
 			// If the shutdown has started, exit the loop.
-			return nil
+			return StopShutdown, nil
 
 		default:
 		}
 
-		err := listener.processTick(ctx)
+		err := processTick(ctx)
 
 		if errors.Is(err, errExitProcessingLoop) {
-			return nil
+			return StopShutdown, nil
 		}
 
 		if errors.Is(err, context.Canceled) {
-			return nil
+			return listener.canceledReason(), nil
 		}
 
 		if err != nil {
-			return errors.Wrap(err, "err := listener.processTick(ctx)")
+			return StopError, errors.Wrap(err, "err := listener.processTick(ctx)")
 		}
 	}
 }
 
 // Shutdown gracefully shuts down the Listener, committing any uncommitted messages
-// and closing the Kafka reader.
+// and closing the Kafka reader. If some partitions couldn't be committed, the returned
+// error wraps a PartialCommitError listing the committed and uncommitted offsets.
+//
+// The shutdown is bounded by the deadline of ctx or, if it has none, by the shutdown timeout, see
+// WithShutdownTimeout. Past it, the reader is closed right away and ErrShutdownTimeout is returned,
+// while the rest of the shutdown sequence completes in the background.
 func (listener *Listener) Shutdown(ctx context.Context) error {
+	return listener.ShutdownWithReason(ctx, "")
+}
+
+// ShutdownWithReason shuts down the Listener like Shutdown and records why, e.g. "SIGTERM" or "deploy",
+// in the logs and in Stats, so the cause of a restart isn't lost.
+func (listener *Listener) ShutdownWithReason(ctx context.Context, reason string) error {
+	_, err := listener.ShutdownWithOffsets(ctx, reason)
+
+	return err
+}
+
+// shutdownResult is what shutdown returns when it runs in the background.
+type shutdownResult struct {
+	committed map[TopicPartition]int64
+	err       error
+}
+
+// ShutdownWithOffsets shuts down the Listener like ShutdownWithReason and returns the highest offset
+// committed by the final commit for every partition, e.g. to log a precise checkpoint. The partitions
+// committed earlier by the commit loop aren't included. If the shutdown times out, no offsets are returned.
+func (listener *Listener) ShutdownWithOffsets(ctx context.Context, reason string) (map[TopicPartition]int64, error) {
+	if reason != "" {
+		listener.log.Printf("Shutting down (reason = %s)", reason)
+
+		listener.uncommittedMsgsMutex.Lock()
+		listener.shutdownReason = reason
+		listener.uncommittedMsgsMutex.Unlock()
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && listener.opts.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, listener.opts.shutdownTimeout)
+		defer cancel()
+
+		deadline, ok = ctx.Deadline()
+	}
+
 	// let's start the shutting down process
+	listener.shutdownCtx = ctx
 	close(listener.shuttingDownCh)
 
+	if !ok {
+		return listener.shutdown(ctx)
+	}
+
+	done := make(chan shutdownResult, 1)
+
+	listener.spawn(func() {
+		committed, err := listener.shutdown(ctx)
+		done <- shutdownResult{committed: committed, err: err}
+	})
+
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+
+	select {
+	case result := <-done:
+		return result.committed, result.err
+
+	case <-timeout.C:
+		// Force close the Kafka reader, which unblocks a wedged fetch or commit.
+		if err := listener.closeReader(); err != nil {
+			listener.opts.metricErrors.Inc()
+
+			listener.log.Errorf(err, "err := listener.closeReader() (Shutdown)")
+		}
+
+		return nil, errors.Wrapf(ErrShutdownTimeout, "deadline = %v", deadline)
+	}
+}
+
+// shutdown waits for the processing to stop, commits the uncommitted messages and closes the reader.
+// It closes the channels once the processing stopped, so nothing is sent on them afterwards.
+// It returns the highest committed offset of every partition.
+func (listener *Listener) shutdown(ctx context.Context) (map[TopicPartition]int64, error) {
 	defer func() {
+		listener.channelsMutex.Lock()
+		defer listener.channelsMutex.Unlock()
+
+		listener.channelsClosed = true
+
 		close(listener.errorChan)
+		close(listener.ackChan)
 		close(listener.messageChan)
+		close(listener.batchChan)
 	}()
 
 	listener.processing.Lock()
 
 	defer listener.processing.Unlock()
 
-	// Commit any uncommitted messages. It's OK to not to process them further as
-	// logs will provide the missing content while trying to commit before shutting down.
-	if err := listener.commitUncommittedMessages(ctx); err != nil {
-		listener.log.Errorf(err, "err := queue.commitUncommittedMessages(ctx)")
+	// Wait for the concurrent handlers and for the commit loop to perform its final commit,
+	// so they never run against a closed reader.
+	listener.workersWaitGroup.Wait()
+	listener.commitLoopWaitGroup.Wait()
+
+	// Commit any uncommitted messages partition by partition, so a slow broker doesn't cost the
+	// progress of every partition. It's OK to not to process them further as logs will provide
+	// the missing content while trying to commit before shutting down.
+	// A canceled or expired ctx is an expected end of a graceful shutdown, so it's not logged as an error.
+	committed, commitErr := listener.commitUncommittedByPartition(ctx)
+	if commitErr != nil && isCanceled(commitErr) {
+		listener.log.Printf("Final commit stopped by the shutdown context (err = %v)", commitErr)
+	} else if commitErr != nil {
+		listener.log.Errorf(commitErr, "committed, commitErr := listener.commitUncommittedByPartition(ctx)")
 	}
 
 	// Close the Kafka reader.
-	if err := listener.reader.Close(); err != nil {
-		go listener.opts.metricErrors.Inc()
+	if err := listener.closeReader(); err != nil {
+		listener.opts.metricErrors.Inc()
+
+		return committed, errors.Wrap(err, "err := listener.closeReader()")
+	}
+
+	// Report the partitions that couldn't be committed, see PartialCommitError, along with the
+	// failure of the final commit of the commit loop, if any.
+	if commitErr != nil && listener.commitLoopErr != nil {
+		return committed, errors.Wrapf(commitErr, "committed, commitErr := listener.commitUncommittedByPartition(ctx) (commit loop: %v)", listener.commitLoopErr)
+	}
+
+	if commitErr != nil {
+		return committed, errors.Wrap(commitErr, "committed, commitErr := listener.commitUncommittedByPartition(ctx)")
+	}
 
-		return errors.Wrap(err, "queue.reader.Close()")
+	return committed, nil
+}
+
+// isCanceled reports whether err is due to a canceled or expired context.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Flush commits the uncommitted messages right away instead of waiting for the commit loop,
+// e.g. before draining the traffic of a deploy. Unlike Shutdown, the Listener keeps running.
+func (listener *Listener) Flush(ctx context.Context) error {
+	_, err := listener.FlushWithOffsets(ctx)
+
+	return err
+}
+
+// FlushWithOffsets flushes like Flush and returns the highest offset it committed for every partition,
+// e.g. to log a precise checkpoint. If some partitions couldn't be committed, the offsets of the ones
+// that were are returned along with a PartialCommitError.
+func (listener *Listener) FlushWithOffsets(ctx context.Context) (map[TopicPartition]int64, error) {
+	committed, err := listener.commitUncommittedOffsets(ctx)
+	if err != nil {
+		return committed, errors.Wrap(err, "committed, err := listener.commitUncommittedOffsets(ctx)")
 	}
 
-	return nil
+	return committed, nil
 }
 
 // MessageAndErrorChannels returns the message and error channels for the Listener.
+// Sending nil on the error channel acknowledges the message and any error skips it, see AckFromError.
 func (listener *Listener) MessageAndErrorChannels() (<-chan []byte, chan<- error) {
 	return listener.messageChan, listener.errorChan
 }
+
+// MessageAndAckChannels returns the message and acknowledgement channels for the Listener.
+// Every delivered message must be answered with exactly one Acknowledgement.
+func (listener *Listener) MessageAndAckChannels() (<-chan []byte, chan<- Acknowledgement) {
+	return listener.messageChan, listener.ackChan
+}
+
+// Started returns a channel that is closed once the Listener fetched its first message from Kafka,
+// so callers can wait for it to be actually consuming. It's the same in both delivery modes, so it
+// stays open while the topic is idle.
+func (listener *Listener) Started() <-chan struct{} {
+	return listener.started
+}
+
+// BatchAndErrorChannels returns the batch and error channels for the Listener.
+// They are only used in the batch delivery mode, see WithBatchDelivery.
+func (listener *Listener) BatchAndErrorChannels() (<-chan []kafka.Message, chan<- error) {
+	return listener.batchChan, listener.errorChan
+}
+
+// BatchAndAckChannels returns the batch and acknowledgement channels for the Listener.
+// The acknowledgement applies to the whole batch, use BatchAndErrorChannels to commit it partially.
+func (listener *Listener) BatchAndAckChannels() (<-chan []kafka.Message, chan<- Acknowledgement) {
+	return listener.batchChan, listener.ackChan
+}
@@ -2,14 +2,31 @@ package kafko
 
 import (
 	"context"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/segmentio/kafka-go"
 )
 
-type ProcessDroppedMsgHandler func(msg *kafka.Message, log Logger) error
+// ProcessDroppedMsgHandler processes a message that couldn't be processed. ctx is the context
+// given to Listen, or to Publish, so the handler can read its values and honor its cancellation.
+type ProcessDroppedMsgHandler func(ctx context.Context, msg *kafka.Message, log Logger) error
+
+// LegacyProcessDroppedMsg adapts a dropped message handler without context to ProcessDroppedMsgHandler.
+func LegacyProcessDroppedMsg(handler func(msg *kafka.Message, log Logger) error) ProcessDroppedMsgHandler {
+	return func(_ context.Context, msg *kafka.Message, log Logger) error {
+		return handler(msg, log)
+	}
+}
+
+// Handler processes a message delivered by ListenFunc. Returning nil commits the message.
+type Handler func(ctx context.Context, message kafka.Message) error
 
 type Logger interface {
 	Printf(format string, v ...any)
@@ -17,6 +34,41 @@ type Logger interface {
 	Errorf(err error, format string, v ...any)
 }
 
+// namedLogger prefixes every log line with the name of the listener.
+type namedLogger struct {
+	name string
+	log  Logger
+}
+
+func (named *namedLogger) Printf(format string, v ...any) {
+	named.log.Printf("[%s] "+format, append([]any{named.name}, v...)...)
+}
+
+func (named *namedLogger) Panicf(err error, format string, v ...any) {
+	named.log.Panicf(err, "[%s] "+format, append([]any{named.name}, v...)...)
+}
+
+func (named *namedLogger) Errorf(err error, format string, v ...any) {
+	named.log.Errorf(err, "[%s] "+format, append([]any{named.name}, v...)...)
+}
+
+func (named *namedLogger) Debugf(format string, v ...any) {
+	debugf(named.log, "[%s] "+format, append([]any{named.name}, v...)...)
+}
+
+// DebugLogger is implemented by the loggers able to log routine events, like the recoverable
+// Kafka errors. Those events aren't logged by the loggers that don't implement it.
+type DebugLogger interface {
+	Debugf(format string, v ...any)
+}
+
+// debugf logs a routine event if the logger implements DebugLogger.
+func debugf(log Logger, format string, v ...any) {
+	if debug, ok := log.(DebugLogger); ok {
+		debug.Debugf(format, v...)
+	}
+}
+
 type Reader interface {
 	Close() error
 	FetchMessage(ctx context.Context) (kafka.Message, error)
@@ -25,14 +77,27 @@ type Reader interface {
 
 var (
 	ErrMessageDropped     = errors.New("message dropped")
+	ErrProcessingTimeout  = errors.New("the processing of the message timed out")
 	ErrResourceIsNil      = errors.New("resource is nil")
+	ErrAlreadyListening   = errors.New("the listener is already listening")
+	ErrShutdownTimeout    = errors.New("the shutdown timed out")
+	ErrInvalidTimeout     = errors.New("the timeout must be positive")
+	ErrInvalidCount       = errors.New("the count must not be negative")
+	ErrReaderClosed       = errors.New("the reader is closed")
 	errExitProcessingLoop = errors.New("listener: exit processing loop")
 )
 
 type Listener struct {
 	messageChan    chan []byte
+	batchChan      chan []kafka.Message
 	errorChan      chan error
+	ackChan        chan Acknowledgement
 	shuttingDownCh chan struct{}
+	shutdownCtx    context.Context //nolint:containedctx // Context given to Shutdown, set before closing shuttingDownCh.
+
+	// started is closed once the first fetch succeeds, see Started.
+	started     chan struct{}
+	startedOnce *sync.Once
 
 	log Logger
 
@@ -40,93 +105,408 @@ type Listener struct {
 
 	processing sync.Locker
 
-	reader Reader
+	// listening is set while Listen, ListenFunc, ConsumeN or ProcessOnce runs and nextCalls counts
+	// the running calls to Next, see ErrAlreadyListening.
+	listening int32
+	nextCalls int32
+
+	// processingTimeout is the global processing timeout in nanoseconds, see SetProcessingTimeout.
+	processingTimeoutNanos int64
+
+	// generation is the generation of the consumer group membership, -1 until known, see Generation.
+	generation int64
+
+	// lastFetch is the Unix time, in nanoseconds, of the last fetched message or, if none, of the creation, see IdleFor.
+	lastFetch int64
+
+	// kafkaFailures counts the recoverable Kafka errors since the last fetched message, see WithInitialBackoff.
+	kafkaFailures int32
+
+	// goroutines counts the running goroutines started by the Listener, see Stats.
+	goroutines int32
+
+	// commitLoopWaitGroup tracks runCommitLoop so Shutdown can wait for its final commit.
+	commitLoopWaitGroup *sync.WaitGroup
+
+	// commitLoopErr is the error of the final commit of runCommitLoop, read once commitLoopWaitGroup is done.
+	commitLoopErr error
 
-	uncommittedMsgs      []kafka.Message
+	// workersWaitGroup tracks the handlers running concurrently, see WithOrderedConcurrency.
+	workersWaitGroup *sync.WaitGroup
+
+	// delivery is the *deliveryContext of the message delivered through the channels, see DeliveryContext.
+	delivery atomic.Value
+
+	reader       Reader
+	readerMutex  *sync.RWMutex // Guards reader against the prefetching, see currentReader.
+	readerClosed bool          // Whether reader was closed, guarded by readerMutex, see closeReader.
+
+	// The buffer filled by runPrefetch and its resume signal, see WithPrefetch. prefetchPaused is guarded by processing.
+	prefetched     chan prefetchResult
+	prefetchResume chan struct{}
+	prefetchPaused bool
+
+	uncommittedMsgs      []uncommittedOffset
 	uncommittedMsgsMutex sync.Locker
+
+	// lastCommittedTime is the time of the newest committed message, guarded by uncommittedMsgsMutex.
+	lastCommittedTime time.Time
+
+	// shutdownReason is the reason given to ShutdownWithReason, guarded by uncommittedMsgsMutex.
+	shutdownReason string
+
+	// peakUncommitted is the highest number of uncommitted messages since the last commit, guarded by
+	// uncommittedMsgsMutex, see runUncommittedSampler.
+	peakUncommitted int
+
+	// The successful reconnections and the time of the last one, guarded by uncommittedMsgsMutex.
+	reconnects    int
+	lastReconnect time.Time
+
+	// The generation of the reader and its assignments as of the last fetch, guarded by processing.
+	hasGeneration bool
+	generationID  int32
+	assignments   []TopicPartition
+	reconnected   bool // Whether the reader was replaced since the last check, see releaseUnowned.
+
+	// processingSlots is the semaphore set by WithProcessingSemaphore, nil if unset.
+	processingSlots chan struct{}
+
+	// checkpointed are the partitions whose position was committed, guarded by processing, see checkpoint.
+	checkpointed map[TopicPartition]bool
+
+	// deliveredOffsets is the highest offset delivered by partition, guarded by processing, see IsRedelivery.
+	deliveredOffsets map[TopicPartition]int64
+
+	// slowFetches counts the consecutive slow fetches, guarded by processing, see WithReaderHealthProbe.
+	slowFetches int
+
+	// resumed is closed by Resume, nil unless drained, and stopFetch interrupts the current fetch, both guarded by
+	// drainMutex, see Drain.
+	drainMutex *sync.Mutex
+	resumed    chan struct{}
+	stopFetch  context.CancelFunc
+
+	// Whether the shutdown closed the channels, guarded by channelsMutex, so Consume never sends on them afterwards.
+	channelsMutex  *sync.RWMutex
+	channelsClosed bool
+
+	// The time of the last check of the heap size and its result, guarded by throttleMutex, see WithAdaptiveThrottle.
+	throttleMutex *sync.Mutex
+	lastMemCheck  time.Time
+	throttled     bool
 }
 
-// processError handles errors in processing messages.
-func (listener *Listener) processError(ctx context.Context, message kafka.Message) error {
+// processError handles errors in processing messages. start is the time the delivery started.
+func (listener *Listener) processError(ctx context.Context, message kafka.Message, start time.Time) error {
+	delivered := time.Now()
+
 	select {
 	case err := <-listener.errorChan:
-		// If there's an error, log it and continue processing.
-		if err != nil {
-			listener.log.Errorf(err, "Failed to process message =%v", message)
+		listener.observeAckLatency(delivered)
 
-			return nil
-		}
+		// If there's an error, log it and continue processing. Otherwise commit the message.
+		return listener.handleAck(ctx, AckFromError(err), err, message)
 
-		// If there's no error, commit the message.
-		if err := listener.doCommitMessage(ctx, message); err != nil {
-			return errors.Wrap(err, "err := queue.doCommitMessage(ctx, message)")
-		}
+	case ack := <-listener.ackChan:
+		listener.observeAckLatency(delivered)
+
+		// Commit, skip or drop the message as acknowledged.
+		return listener.handleAck(ctx, ack, nil, message)
+
+	case <-time.After(listener.ackTimeout(message)):
+		// Let the consumer abort the work whose result would be ignored, see DeliveryContext.
+		listener.cancelDelivery()
+		listener.onTimeout(start, message)
 
-	case <-time.After(listener.opts.processingTimeout):
 		// If processing times out, attempt to process the dropped message.
-		if err := listener.opts.processDroppedMsg(&message, listener.log); err != nil {
-			listener.log.Errorf(err, "Failed to process message")
-		}
+		return listener.commitDropped(ctx, ErrProcessingTimeout, message)
+
+	case <-listener.inFlightShutdownCh():
+		// If the shutdown has started, keep waiting for the acknowledgement.
+		return listener.awaitInFlightAck(message)
+	}
+}
+
+// observeAckLatency reports the time from the delivery to the acknowledgement, see WithMetricAckLatency.
+func (listener *Listener) observeAckLatency(delivered time.Time) {
+	latency := time.Since(delivered)
+	listener.opts.metricAckLatency.Observe(float64(latency.Milliseconds()))
+}
+
+// processingTimeout returns the processing timeout of the message, that is the time left until its
+// deadline if WithDeadlineFromMessageTime is set, otherwise the one of its topic, extended by the
+// timeout header if it's set, up to the maximum processing timeout.
+func (listener *Listener) processingTimeout(message kafka.Message) time.Duration {
+	if remaining, ok := listener.untilDeadline(message); ok {
+		return remaining
+	}
+
+	return listener.extendTimeout(message, listener.topicProcessingTimeout(message.Topic))
+}
+
+// deliveryTimeout returns how long the delivery of the message waits for the consumer to receive it,
+// see WithDeliveryTimeout.
+func (listener *Listener) deliveryTimeout(message kafka.Message) time.Duration {
+	if listener.opts.deliveryTimeout > 0 {
+		return listener.opts.deliveryTimeout
+	}
+
+	return listener.processingTimeout(message)
+}
+
+// ackTimeout returns how long the acknowledgement of the delivered message is waited for, extended
+// by the timeout header like the processing timeout, see WithAckTimeout.
+func (listener *Listener) ackTimeout(message kafka.Message) time.Duration {
+	if listener.opts.ackTimeout > 0 {
+		return listener.extendTimeout(message, listener.opts.ackTimeout)
+	}
+
+	return listener.processingTimeout(message)
+}
+
+// extendTimeout returns the given timeout extended by the timeout header of the message if it's set,
+// up to the maximum processing timeout.
+func (listener *Listener) extendTimeout(message kafka.Message, timeout time.Duration) time.Duration {
+	if listener.opts.timeoutHeader == "" {
+		return timeout
 	}
 
+	value, found := headerValue(message, listener.opts.timeoutHeader)
+	if !found {
+		return timeout
+	}
+
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		listener.log.Errorf(err, "milliseconds, err := strconv.ParseInt(value, 10, 64) (value = %q)", value)
+
+		return timeout
+	}
+
+	extended := time.Duration(milliseconds) * time.Millisecond
+	if extended > listener.opts.maxProcessingTimeout {
+		extended = listener.opts.maxProcessingTimeout
+	}
+
+	if extended > timeout {
+		return extended
+	}
+
+	return timeout
+}
+
+// SetProcessingTimeout replaces the global processing timeout while the Listener runs, e.g. from an
+// admin endpoint during an incident. It applies to the next deliveries, the timeouts of the topics
+// set by WithTopicProcessingTimeout are kept. It returns ErrInvalidTimeout if timeout isn't positive.
+func (listener *Listener) SetProcessingTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.Wrapf(ErrInvalidTimeout, "timeout = %v", timeout)
+	}
+
+	atomic.StoreInt64(&listener.processingTimeoutNanos, int64(timeout))
+
 	return nil
 }
 
+// globalProcessingTimeout returns the processing timeout of the topics without their own, see SetProcessingTimeout.
+func (listener *Listener) globalProcessingTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&listener.processingTimeoutNanos))
+}
+
+// topicProcessingTimeout returns the processing timeout of the topic, see WithTopicProcessingTimeout.
+func (listener *Listener) topicProcessingTimeout(topic string) time.Duration {
+	if timeout, ok := listener.opts.topicProcessingTimeout[topic]; ok {
+		return timeout
+	}
+
+	return listener.globalProcessingTimeout()
+}
+
+// batchProcessingTimeout returns the longest processing timeout of the topics of the batch.
+func (listener *Listener) batchProcessingTimeout(batch []kafka.Message) time.Duration {
+	if len(batch) == 0 {
+		return listener.globalProcessingTimeout()
+	}
+
+	var timeout time.Duration
+
+	for _, message := range batch {
+		if topicTimeout := listener.topicProcessingTimeout(message.Topic); topicTimeout > timeout {
+			timeout = topicTimeout
+		}
+	}
+
+	return timeout
+}
+
+// batchDeliveryTimeout returns how long the delivery of the batch waits for the consumer to receive it.
+func (listener *Listener) batchDeliveryTimeout(batch []kafka.Message) time.Duration {
+	if listener.opts.deliveryTimeout > 0 {
+		return listener.opts.deliveryTimeout
+	}
+
+	return listener.batchProcessingTimeout(batch)
+}
+
+// batchAckTimeout returns how long the acknowledgement of the delivered batch is waited for.
+func (listener *Listener) batchAckTimeout(batch []kafka.Message) time.Duration {
+	if listener.opts.ackTimeout > 0 {
+		return listener.opts.ackTimeout
+	}
+
+	return listener.batchProcessingTimeout(batch)
+}
+
+// onTimeout calls the timeout hook for every message whose processing timed out.
+func (listener *Listener) onTimeout(start time.Time, messages ...kafka.Message) {
+	elapsed := time.Since(start)
+
+	for _, message := range messages {
+		listener.opts.onTimeout(message, elapsed)
+	}
+}
+
+// inFlightShutdownCh returns the channel signaling the shutdown to the acknowledgement waits
+// if WithCommitInFlightOnShutdown is enabled, otherwise it returns a nil channel that never fires.
+func (listener *Listener) inFlightShutdownCh() <-chan struct{} {
+	if !listener.opts.commitInFlightOnShutdown {
+		return nil
+	}
+
+	return listener.shuttingDownCh
+}
+
+// awaitInFlightAck waits for the acknowledgement of the in-flight messages once the shutdown
+// has started, bounded by the context given to Shutdown instead of the processing timeout.
+// The acknowledged messages are left as uncommitted so Shutdown commits them.
+func (listener *Listener) awaitInFlightAck(messages ...kafka.Message) error {
+	var processed []kafka.Message
+
+	select {
+	case err := <-listener.errorChan:
+		processed = listener.resolveAck(listener.shutdownCtx, AckFromError(err), err, messages)
+
+	case ack := <-listener.ackChan:
+		processed = listener.resolveAck(listener.shutdownCtx, ack, nil, messages)
+
+	case <-listener.shutdownCtx.Done():
+		listener.log.Printf("Shutdown before the in-flight messages were acknowledged, they will be redelivered")
+	}
+
+	if len(processed) > 0 {
+		listener.addUncommittedMsg(processed...)
+	}
+
+	return errExitProcessingLoop
+}
+
 // processMessageAndError processes the given message and handles any errors
 // that occur during processing, following a similar approach to processError.
 func (listener *Listener) processMessageAndError(ctx context.Context, message kafka.Message) error {
 	start := time.Now()
 
-	select {
-	case listener.messageChan <- message.Value:
-		// Process the message and handle any errors.
-		if err := listener.processError(ctx, message); err != nil {
-			return errors.Wrap(err, "err := listener.processError(ctx, message)")
-		}
+	defer listener.startDelivery(ctx)()
 
-		duration := time.Since(start)
-		listener.opts.metricDurationProcess.Observe(float64(duration.Milliseconds()))
+	deliveryTimeout := time.After(listener.deliveryTimeout(message))
 
-	case <-time.After(listener.opts.processingTimeout):
-		// Attempt to empty the listener.lastMsg channel if there is a message.
+	for {
 		select {
-		case _, closed := <-listener.messageChan:
-			if closed {
-				// If the listener.messageChan has been closed, exit the loop.
-				return nil
+		case listener.messageChan <- message.Value:
+			// Process the message and handle any errors.
+			if err := listener.processError(ctx, message, start); err != nil {
+				return errors.Wrap(err, "err := listener.processError(ctx, message, start)")
 			}
-		default:
-		}
 
-		go listener.opts.metricMessagesDropped.Inc()
+			duration := time.Since(start)
+			listener.opts.metricDurationProcess.Observe(float64(duration.Milliseconds()))
 
-		// If processing times out, attempt to process the dropped message.
-		if err := listener.opts.processDroppedMsg(&message, listener.log); err != nil {
-			listener.log.Errorf(err, "Failed to process message")
+			return nil
+
+		case <-listener.lateErrors():
+			listener.discardLateAck()
+
+		case <-listener.lateAcks():
+			listener.discardLateAck()
+
+		case <-deliveryTimeout:
+			// Attempt to empty the listener.lastMsg channel if there is a message.
+			select {
+			case _, closed := <-listener.messageChan:
+				if closed {
+					// If the listener.messageChan has been closed, exit the loop.
+					return nil
+				}
+			default:
+			}
+
+			listener.cancelDelivery()
+			listener.onTimeout(start, message)
+
+			// If processing times out, attempt to process the dropped message.
+			return listener.commitDropped(ctx, ErrProcessingTimeout, message)
 		}
 	}
+}
+
+// processHandler calls the handler with the given message and commits it if the handler
+// succeeds, otherwise the message is retried or processed as a dropped message, see failMessages.
+func (listener *Listener) processHandler(ctx context.Context, message kafka.Message, handler Handler) error {
+	start := time.Now()
+
+	if err := handler(ctx, message); err != nil {
+		listener.log.Errorf(err, "Failed to process message =%v", message)
+
+		return listener.commitFailed(ctx, err, message)
+	}
+
+	duration := time.Since(start)
+	listener.opts.metricDurationProcess.Observe(float64(duration.Milliseconds()))
+
+	if err := listener.doCommitMessage(ctx, message); err != nil {
+		return errors.Wrap(err, "err := listener.doCommitMessage(ctx, message)")
+	}
 
 	return nil
 }
 
-// addUncommittedMsg appends the given message to the list of uncommitted messages.
+// addUncommittedMsg appends the given messages to the list of uncommitted messages.
 // It locks the uncommittedMsgsMutex to ensure safe concurrent access to the uncommittedMsgs slice.
-func (listener *Listener) addUncommittedMsg(message kafka.Message) {
+func (listener *Listener) addUncommittedMsg(messages ...kafka.Message) {
 	// Lock the mutex before accessing uncommittedMsgs.
 	listener.uncommittedMsgsMutex.Lock()
 
 	// Unlock the mutex after finishing.
 	defer listener.uncommittedMsgsMutex.Unlock()
 
-	// Add the message to the uncommittedMsgs slice.
-	listener.uncommittedMsgs = append(listener.uncommittedMsgs, message)
+	// Add the offsets of the messages to the uncommittedMsgs slice.
+	listener.uncommittedMsgs = append(listener.uncommittedMsgs, newUncommittedOffsets(messages)...)
+
+	// Until the shutdown, only the highest offset of every partition needs to be kept.
+	if listener.opts.commitOnShutdownOnly {
+		listener.uncommittedMsgs = highestOffsets(listener.uncommittedMsgs)
+	}
+
+	if len(listener.uncommittedMsgs) > listener.peakUncommitted {
+		listener.peakUncommitted = len(listener.uncommittedMsgs)
+	}
 }
 
-// doCommitMessage adds the given message to the list of uncommitted messages
+// doCommitMessage adds the given messages to the list of uncommitted messages
 // and commits all uncommitted messages.
-func (listener *Listener) doCommitMessage(ctx context.Context, message kafka.Message) error {
-	// Add the message to the list of uncommitted messages.
-	listener.addUncommittedMsg(message)
+func (listener *Listener) doCommitMessage(ctx context.Context, messages ...kafka.Message) error {
+	// They were committed on fetch, see CommitBeforeProcess.
+	if listener.committedBeforeProcess() {
+		return nil
+	}
+
+	// Add the messages to the list of uncommitted messages.
+	listener.addUncommittedMsg(messages...)
+
+	// Leave them for Shutdown, see WithCommitOnShutdownOnly.
+	if listener.opts.commitOnShutdownOnly {
+		return nil
+	}
 
 	// Attempt to commit all uncommitted messages.
 	if err := listener.commitUncommittedMessages(ctx); err != nil {
@@ -151,11 +531,11 @@ func (listener *Listener) handleKafkaError(ctx context.Context, err error) error
 
 	if errors.As(err, &kafkaError) {
 		if kafkaError.Temporary() || kafkaError.Timeout() {
-			listener.log.Printf("Kafka error, but this is a recoverable error so let's retry. Reason = %v", err)
+			debugf(listener.log, "Kafka error, but this is a recoverable error so let's retry. Reason = %v", err)
 
 			select {
-			// Let's reconnect after queue.reconnectInterval.
-			case <-time.After(listener.opts.reconnectInterval):
+			// Let's reconnect after the backoff, see WithInitialBackoff.
+			case <-time.After(listener.reconnectBackoff()):
 				listener.reconnectToKafka()
 
 			// If ctx.Done and reconnect hasn't started yet, then it's secure to exit.
@@ -174,32 +554,125 @@ func (listener *Listener) handleKafkaError(ctx context.Context, err error) error
 		}
 	}
 
+	// A blocked fetch fails once Shutdown closes the reader under it, which is a clean exit.
+	// The prefetching already fetches again when a reconnection replaces the reader, see runPrefetch.
+	if isClosedReaderError(err) {
+		select {
+		case <-listener.shuttingDownCh:
+			return errExitProcessingLoop
+		default:
+		}
+	}
+
 	// If the error is not recoverable, wrap and return it.
 	return errors.Wrapf(err, "Failed to commit message, unrecoverable error")
 }
 
+// isClosedReaderError reports whether err is what kafka-go returns when the reader is closed
+// under a fetch or a commit, see closeReader.
+func isClosedReaderError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) || errors.Is(err, ErrReaderClosed)
+}
+
+// reconnectBackoff returns the time to wait before reconnecting to Kafka. Without an initial backoff,
+// it's always the reconnect interval. Otherwise, it starts at the initial backoff and doubles on every
+// consecutive failure, up to the maximum backoff.
+func (listener *Listener) reconnectBackoff() time.Duration {
+	if listener.opts.initialBackoff <= 0 {
+		return listener.opts.reconnectInterval
+	}
+
+	maxBackoff := listener.opts.maxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = listener.opts.reconnectInterval
+	}
+
+	failures := atomic.AddInt32(&listener.kafkaFailures, 1) - 1
+	backoff := listener.opts.initialBackoff
+
+	for attempt := int32(0); attempt < failures && backoff < maxBackoff; attempt++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+
+	return backoff
+}
+
 // commitUncommittedMessages commits all uncommitted messages to Kafka.
 // It locks the uncommittedMsgsMutex to avoid concurrent access to uncommittedMsgs.
 func (listener *Listener) commitUncommittedMessages(ctx context.Context) error {
+	_, err := listener.commitUncommittedOffsets(ctx)
+
+	return err
+}
+
+// commitUncommittedOffsets commits all uncommitted messages to Kafka like commitUncommittedMessages
+// and returns the highest committed offset of every partition.
+func (listener *Listener) commitUncommittedOffsets(ctx context.Context) (map[TopicPartition]int64, error) {
 	// Lock the mutex to avoid concurrent access to uncommitted messages.
 	listener.uncommittedMsgsMutex.Lock()
 	defer listener.uncommittedMsgsMutex.Unlock()
 
-	// If there are uncommitted messages, attempt to commit them.
-	if len(listener.uncommittedMsgs) > 0 {
-		if err := listener.reader.CommitMessages(ctx, listener.uncommittedMsgs...); err != nil {
-			go listener.opts.metricErrors.Inc()
+	// If there are no uncommitted messages, there's nothing to commit.
+	if len(listener.uncommittedMsgs) == 0 {
+		return map[TopicPartition]int64{}, nil
+	}
+
+	messages := uncommittedMessages(listener.uncommittedMsgs)
+
+	if err := listener.commitMessages(ctx, messages); err != nil {
+		listener.opts.metricErrors.Inc()
 
-			return errors.Wrapf(err, "err := queue.reader.CommitMessages(ctx, queue.uncommittedMsgs...) (queue.uncommittedMsgs = %v)", listener.uncommittedMsgs)
+		// The commit may have succeeded for some partitions, so retry them one at a time
+		// to keep only the genuinely uncommitted ones.
+		if partitions, _ := groupByPartition(messages); len(partitions) > 1 {
+			committed, partialErr := listener.commitByPartition(ctx, messages)
+			if partialErr != nil {
+				return committed, errors.Wrapf(partialErr, "committed, partialErr := listener.commitByPartition(ctx, messages) (err = %v)", err)
+			}
+
+			return committed, nil
 		}
 
-		go listener.opts.metricMessagesProcessed.Inc()
+		return nil, errors.Wrapf(err, "err := queue.reader.CommitMessages(ctx, messages...) (messages = %v)", messages)
+	}
+
+	listener.opts.metricMessagesProcessed.Inc()
+	listener.trackCommitted(messages)
+	listener.reportOutcome(OutcomeCommitted, messages...)
 
-		// Reset the uncommitted messages slice.
-		listener.uncommittedMsgs = nil
+	// Reset the uncommitted messages slice.
+	listener.uncommittedMsgs = nil
+	listener.peakUncommitted = 0
+
+	return offsetsByPartition(messages), nil
+}
+
+// finalCommitContext returns the context of the final commit of the commit loop. It's not derived from the
+// context of the loop, which is done by then, but bounded by the commit timeout, see WithCommitTimeout, and
+// during a shutdown by the context given to Shutdown.
+func (listener *Listener) finalCommitContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), listener.opts.commitTimeout)
+
+	// shutdownCtx is set before closing shuttingDownCh.
+	select {
+	case <-listener.shuttingDownCh:
+		shutdownCtx := listener.shutdownCtx
+
+		listener.spawn(func() {
+			select {
+			case <-shutdownCtx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		})
+	default:
 	}
 
-	return nil
+	return ctx, cancel
 }
 
 // runCommitLoop is a method of the Listener struct that handles periodic committing of uncommitted messages.
@@ -207,29 +680,76 @@ func (listener *Listener) commitUncommittedMessages(ctx context.Context) error {
 //
 // The method uses a ticker to trigger periodic commits and makes use of a defer function to ensure proper cleanup
 // in case of a panic or other unexpected situations. The defer function stops the ticker and attempts to commit any
-// remaining uncommitted messages.
+// remaining uncommitted messages. If a jitter is set, the ticker is replaced by a timer that is reset with a
+// randomized interval after every attempt.
 //
 // This method is part of a message processing system and is typically used in conjunction with other methods that handle
 // message reception and processing.
 func (listener *Listener) runCommitLoop(ctx context.Context) {
+	ticks := listener.opts.recommitTicker.C
+
+	var (
+		timer  *time.Timer
+		random *rand.Rand
+	)
+
+	// The ticker was stopped by the commit loop of a previous Listen, if any.
+	listener.opts.recommitTicker.Reset(listener.opts.recommitInterval)
+
+	if listener.opts.recommitJitter > 0 {
+		listener.opts.recommitTicker.Stop()
+
+		random = rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // The jitter doesn't need a secure source.
+		timer = time.NewTimer(listener.jitteredRecommitInterval(random))
+		ticks = timer.C
+	}
+
 	// Add the defer function to handle stopping the ticker and committing uncommitted messages
 	// in case the method returns due to a panic or other unexpected situations.
 	defer func() {
 		listener.opts.recommitTicker.Stop()
 
-		if err := listener.commitUncommittedMessages(ctx); err != nil {
-			listener.log.Errorf(err, "err := queue.commitUncommittedMessages(ctx)")
+		if timer != nil {
+			timer.Stop()
+		}
+
+		// The loop stops once ctx is canceled, so the final commit gets a context of its own to
+		// persist the offsets anyway, see finalCommitContext.
+		commitCtx, cancel := listener.finalCommitContext()
+		defer cancel()
+
+		// The messages stay uncommitted if the final commit fails, so Shutdown retries them
+		// and reports this error along with its own, see shutdown. As the shutdown bounds the
+		// final commit, a canceled commit is expected and not logged as an error.
+		if err := listener.commitUncommittedMessages(commitCtx); err != nil {
+			if isCanceled(err) {
+				listener.log.Printf("Final commit of the commit loop canceled, Shutdown retries it (err = %v)", err)
+			} else {
+				listener.log.Errorf(err, "err := queue.commitUncommittedMessages(ctx)")
+			}
+
+			listener.commitLoopErr = err
 		}
 	}()
 
 	for {
 		select {
-		case <-listener.opts.recommitTicker.C:
+		case <-ticks:
+			// Report the uncommitted messages piled up since the last commit, see WithMetricUncommittedDepth.
+			if listener.opts.samplesUncommitted() {
+				listener.sampleUncommitted()
+			}
+
 			// When the ticker ticks, commit uncommitted messages.
 			if err := listener.commitUncommittedMessages(ctx); err != nil {
 				listener.log.Errorf(err, "err := queue.commitUncommittedMessages(ctx)")
 			}
 
+			// Schedule the next jittered attempt.
+			if timer != nil {
+				timer.Reset(listener.jitteredRecommitInterval(random))
+			}
+
 		case <-listener.shuttingDownCh:
 			// If the shutdown has started, exit the loop.
 			return
@@ -241,22 +761,113 @@ func (listener *Listener) runCommitLoop(ctx context.Context) {
 	}
 }
 
+// jitteredRecommitInterval returns the recommit interval shifted by a random amount within
+// ±recommitJitter of it.
+func (listener *Listener) jitteredRecommitInterval(random *rand.Rand) time.Duration {
+	interval := float64(listener.opts.recommitInterval)
+	shift := interval * listener.opts.recommitJitter * (2*random.Float64() - 1)
+
+	return time.Duration(interval + shift)
+}
+
 // reconnectToKafka attempts to reconnect the Listener to the Kafka broker.
 // It returns an error if the connection fails.
 func (listener *Listener) reconnectToKafka() {
+	start := time.Now()
+	failed := false
+
 	// Close the existing reader in order to avoid resource leaks
-	if err := listener.reader.Close(); err != nil {
-		go listener.opts.metricErrors.Inc()
+	if err := listener.closeReader(); err != nil {
+		listener.opts.metricErrors.Inc()
 
-		listener.log.Errorf(err, "err := listener.reader.Close()")
+		listener.log.Errorf(err, "err := listener.closeReader()")
+
+		failed = true
 	}
 
 	// Create a new Reader from the readerFactory.
+	reader := listener.openReader()
+
+	listener.reconnected = true
+	listener.checkpointed = nil
+
+	if reader == nil {
+		listener.log.Errorf(ErrResourceIsNil, "reader := listener.opts.readerFactory()")
+
+		failed = true
+	}
+
+	duration := time.Since(start)
+	listener.opts.metricReconnectDuration.Observe(float64(duration.Milliseconds()))
+
+	if failed {
+		listener.opts.metricReconnectFailures.Inc()
+
+		return
+	}
+
+	listener.opts.metricReconnects.Inc()
+	listener.trackReconnect()
+}
+
+// openReader replaces the reader by a new one from the readerFactory and returns it.
+func (listener *Listener) openReader() Reader {
 	reader := listener.opts.readerFactory()
+
+	listener.readerMutex.Lock()
 	listener.reader = reader
+	listener.readerClosed = false
+	listener.readerMutex.Unlock()
+
+	return reader
+}
+
+// skipMessage tells whether the message must be skipped instead of delivered, and reports it
+// as filtered. Its offset is committed along with the next processed message.
+func (listener *Listener) skipMessage(message kafka.Message) bool {
+	// The tombstones have no value either, but they are processed by the tombstone handler.
+	if listener.opts.tombstoneHandler != nil && IsTombstone(message) {
+		return false
+	}
+
+	if listener.opts.skipEmptyMessages && len(message.Value) == 0 {
+		debugf(listener.log, "Skipping empty message =%v", message)
+		listener.reportOutcome(OutcomeFiltered, message)
+
+		return true
+	}
+
+	if listener.stale(message) {
+		debugf(listener.log, "Skipping stale message (partition = %d, offset = %d, time = %v)",
+			message.Partition, message.Offset, message.Time)
+		listener.opts.metricMessagesStale.Inc()
+		listener.reportOutcome(OutcomeFiltered, message)
+
+		return true
+	}
+
+	return false
 }
 
-func (listener *Listener) processTick(ctx context.Context) error {
+// markStarted closes the started channel after the first fetched message, resets the backoff
+// of the reconnections and records the time of the fetch, see IdleFor. It's called for every
+// fetched message, in both delivery modes, so an idle topic doesn't count as a successful fetch.
+func (listener *Listener) markStarted() {
+	atomic.StoreInt32(&listener.kafkaFailures, 0)
+	atomic.StoreInt64(&listener.lastFetch, time.Now().UnixNano())
+
+	listener.startedOnce.Do(func() {
+		close(listener.started)
+	})
+}
+
+// processTick fetches a message and hands it to deliver.
+func (listener *Listener) processTick(ctx context.Context, deliver Handler) error {
+	// Don't fetch while drained, see Drain.
+	if err := listener.awaitResume(ctx); err != nil {
+		return err
+	}
+
 	listener.processing.Lock()
 
 	defer listener.processing.Unlock()
@@ -267,11 +878,29 @@ func (listener *Listener) processTick(ctx context.Context) error {
 	default:
 	}
 
-	message, err := listener.reader.FetchMessage(ctx)
+	if listener.draining() {
+		return nil
+	}
+
+	fetchCtx, releaseFetch := listener.fetchContext(ctx)
+	fetchStart := time.Now()
+	message, err := listener.fetchMessage(fetchCtx)
+
+	// The fetch was interrupted by Drain.
+	interrupted := err != nil && fetchCtx.Err() != nil && ctx.Err() == nil
+
+	releaseFetch()
+
+	if interrupted {
+		return nil
+	}
 
 	// If there's an error, handle the message error and continue to the next iteration.
 	if err != nil {
-		go listener.opts.metricErrors.Inc()
+		listener.opts.metricErrors.Inc()
+
+		// Let the prefetching go on once the error was handled, see WithPrefetch.
+		defer listener.resumePrefetch()
 
 		if err := listener.handleKafkaError(ctx, err); err != nil {
 			return errors.Wrap(err, "err := listener.handleKafkaError(ctx, err)")
@@ -280,19 +909,53 @@ func (listener *Listener) processTick(ctx context.Context) error {
 		return nil
 	}
 
+	listener.opts.metricMessagesFetched.Inc()
+	listener.markStarted()
+	listener.checkGeneration(ctx)
+	listener.checkpoint(ctx, message)
+
+	// Swap a degraded reader once the message is processed, see WithReaderHealthProbe.
+	if latency := time.Since(fetchStart); listener.probeFetch(latency) {
+		defer listener.swapSlowReader(latency)
+	}
+
+	if listener.skipMessage(message) {
+		return nil
+	}
+
+	// Hold the messages of a retry topic until they are due, see WithRetryTopics.
+	if err := listener.awaitRetryDelay(ctx, message); err != nil {
+		return err
+	}
+
+	// Commit the message before its delivery, see CommitBeforeProcess.
+	if deliverable, err := listener.commitBeforeProcess(ctx, message); !deliverable {
+		return err
+	}
+
 	// Process the message and handle any errors.
-	if err := listener.processMessageAndError(ctx, message); err != nil {
-		return errors.Wrap(err, "err := listener.processMessage(ctx, message)")
+	if err := deliver(listener.withGeneration(listener.trackDelivery(ctx, message)), message); err != nil {
+		return errors.Wrap(err, "err := deliver(ctx, message)")
 	}
 
 	return nil
 }
 
 // NewListener creates a new Listener instance with the provided configuration,
-// logger, and optional custom options.
+// logger, and optional custom options. It panics if the options are incompatible, see Validate.
 func NewListener(log Logger, opts ...*OptionsListener) *Listener {
 	finalOpts := obtainFinalOptsListener(log, opts)
 
+	// Refuse the options that would silently misbehave.
+	if err := finalOpts.Validate(); err != nil {
+		log.Panicf(err, "err := finalOpts.Validate()")
+	}
+
+	// Prefix the log lines with the listener name, if any.
+	if finalOpts.name != "" {
+		log = &namedLogger{name: finalOpts.name, log: log}
+	}
+
 	// messageChan should have a buffer size of 1 to accommodate for the case when
 	// the consumer did not process the message within the `processingTimeout` period.
 	// In the Listen method, we attempt to empty the listener.messageChan channel (only once)
@@ -305,22 +968,67 @@ func NewListener(log Logger, opts ...*OptionsListener) *Listener {
 	// if the receiver is not ready to receive it yet.
 	errorChan := make(chan error, 1)
 
+	// ackChan is the typed counterpart of errorChan, so it's buffered for the same reason.
+	ackChan := make(chan Acknowledgement, 1)
+
+	// batchChan follows the same reasoning as messageChan, but for the batch delivery mode.
+	batchChan := make(chan []kafka.Message, 1)
+
+	// With WithUnbufferedAck, the deliveries and the acknowledgements are synchronous handoffs instead,
+	// so a late acknowledgement can't linger in a buffer, see discardLateAck.
+	if finalOpts.unbufferedAck {
+		messageChan = make(chan []byte)
+		errorChan = make(chan error)
+		ackChan = make(chan Acknowledgement)
+		batchChan = make(chan []kafka.Message)
+	}
+
 	shuttingDownCh := make(chan struct{}, 1)
 
+	var processingSlots chan struct{}
+	if finalOpts.processingSlots > 0 {
+		processingSlots = make(chan struct{}, finalOpts.processingSlots)
+	}
+
+	reader := finalOpts.readerFactory()
+
+	// Refuse the rebalance options that would never apply to the reader.
+	if err := validateReader(reader, opts); err != nil {
+		log.Panicf(err, "err := validateReader(reader, opts)")
+	}
+
 	// Create and return a new Listener instance with the final configuration,
 	// channels, and options.
 	return &Listener{
 		messageChan:    messageChan,
+		batchChan:      batchChan,
 		errorChan:      errorChan,
+		ackChan:        ackChan,
 		shuttingDownCh: shuttingDownCh,
 
+		processingSlots: processingSlots,
+
+		started:     make(chan struct{}),
+		startedOnce: &sync.Once{},
+
 		processing:           &sync.Mutex{},
+		commitLoopWaitGroup:  &sync.WaitGroup{},
+		workersWaitGroup:     &sync.WaitGroup{},
 		uncommittedMsgsMutex: &sync.Mutex{},
-		uncommittedMsgs:      make([]kafka.Message, 0),
+		readerMutex:          &sync.RWMutex{},
+		throttleMutex:        &sync.Mutex{},
+		drainMutex:           &sync.Mutex{},
+		channelsMutex:        &sync.RWMutex{},
+		uncommittedMsgs:      make([]uncommittedOffset, 0),
+		deliveredOffsets:     map[TopicPartition]int64{},
 
 		log:  log,
 		opts: finalOpts,
 
-		reader: finalOpts.readerFactory(),
+		processingTimeoutNanos: int64(finalOpts.processingTimeout),
+		generation:             -1,
+		lastFetch:              time.Now().UnixNano(),
+
+		reader: reader,
 	}
 }
@@ -4,7 +4,11 @@ package kafko_test
 import (
 	"context"
 	"errors"
+	"io"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	listener "github.com/m3co/kafko"
+	"github.com/m3co/kafko/kafkotest"
 	log "github.com/m3co/kafko/log"
 )
 
@@ -56,7 +61,7 @@ func TestSuccessfulMessageProcessing(t *testing.T) {
 
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -107,7 +112,7 @@ func TestUnrecoverableErrorDuringMessageFetching(t *testing.T) {
 
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, errorAtFetchMessage)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -159,7 +164,7 @@ func TestRecoverableErrorDuringMessageFetching(t *testing.T) {
 
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, &errorAtFetchMessage)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -214,7 +219,7 @@ func TestMessageProcessingTimeout(t *testing.T) {
 	msg := []byte("test message")
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -234,7 +239,7 @@ func TestMessageProcessingTimeout(t *testing.T) {
 
 			return mockReader
 		}).
-		WithProcessDroppedMsg(func(msg *kafka.Message, log listener.Logger) error {
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
 			droppedMessages++
 
 			return errors.New("msg dropped") //nolint:goerr113
@@ -274,12 +279,17 @@ func TestMessageProcessingTimeout(t *testing.T) {
 func TestCommitMessagesFailure(t *testing.T) { //nolint:funlen
 	t.Parallel()
 
+	type (
+		PartialCommitError = listener.PartialCommitError
+		TopicPartition     = listener.TopicPartition
+	)
+
 	msg := []byte("test message")
 	errorAtCommitMessages := kafka.NetworkException
 
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(&errorAtCommitMessages)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(&errorAtCommitMessages)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -287,8 +297,8 @@ func TestCommitMessagesFailure(t *testing.T) { //nolint:funlen
 	reconnections := 0
 
 	expectedLogs := &log.MockLogger{
-		PrintMessages: []string{
-			"Kafka error, but this is a recoverable error so let's retry. Reason = err := queue.reader.CommitMessages(ctx, queue.uncommittedMsgs...) (queue.uncommittedMsgs = [{ 0 0 0 [] [116 101 115 116 32 109 101 115 115 97 103 101] [] <nil> 0001-01-01 00:00:00 +0000 UTC}]): [13] : ",
+		DebugMessages: []string{
+			"Kafka error, but this is a recoverable error so let's retry. Reason = err := queue.reader.CommitMessages(ctx, messages...) (messages = [{ 0 0 0 [] [] [] <nil> 0001-01-01 00:00:00 +0000 UTC}]): [13] : ",
 		},
 	}
 
@@ -323,8 +333,12 @@ func TestCommitMessagesFailure(t *testing.T) { //nolint:funlen
 		// If we are here it's because we have the expected message
 		msgProcessed = true
 
+		// The message can't be committed, so Shutdown reports its partition as uncommitted.
+		var partial *PartialCommitError
+
 		err := listener.Shutdown(ctx)
-		assert.NoError(t, err)
+		assert.ErrorAs(t, err, &partial)
+		assert.Equal(t, map[TopicPartition]int64{{}: 0}, partial.Uncommitted)
 	}()
 
 	err := listener.Listen(ctx)
@@ -334,7 +348,8 @@ func TestCommitMessagesFailure(t *testing.T) { //nolint:funlen
 	assert.NoError(t, err)
 	assert.True(t, msgProcessed)
 	assert.Equal(t, 1, reconnections)
-	assert.Equal(t, expectedLogs.PrintMessages, logs.PrintMessages)
+	assert.Equal(t, expectedLogs.DebugMessages, logs.DebugMessages)
+	assert.Empty(t, logs.PrintMessages)
 }
 
 // TestGracefulShutdownDuringMessageProcessing checks that the listener
@@ -346,7 +361,7 @@ func TestGracefulShutdownDuringMessageProcessing(t *testing.T) {
 
 	mockReader := new(MockKafkaReader)
 	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
-	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Value: msg}}).Return(nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
 	mockReader.On("Close").Return(nil)
 
 	// Set up a simple logger to collect logs during testing
@@ -391,3 +406,4282 @@ func TestGracefulShutdownDuringMessageProcessing(t *testing.T) {
 
 	<-listenerFinished
 }
+
+// TestListenerName checks that the name set with WithName prefixes the log
+// lines of the listener and labels its Stats.
+func TestListenerName(t *testing.T) {
+	t.Parallel()
+
+	errorAtFetchMessage := kafka.NetworkException
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, &errorAtFetchMessage)
+	mockReader.On("Close").Return(nil)
+
+	logs := log.NewMockLogger()
+
+	opts := listener.NewOptionsListener().
+		WithName("orders").
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	listener := listener.NewListener(logs, opts)
+
+	assert.ErrorIs(t, listener.Listen(ctx), context.DeadlineExceeded)
+	assert.Equal(t, "orders", listener.Stats().Name)
+	assert.NotEmpty(t, logs.DebugMessages)
+	assert.True(t, strings.HasPrefix(logs.DebugMessages[0], "[orders] "))
+}
+
+// TestShutdownWithReason checks that the shutdown reason is logged and reported by Stats.
+func TestShutdownWithReason(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader()
+	logs := log.NewMockLogger()
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(logs, opts)
+	assert.Empty(t, consumer.Stats().ShutdownReason)
+
+	assert.NoError(t, consumer.ShutdownWithReason(ctx, "SIGTERM"))
+	assert.Equal(t, "SIGTERM", consumer.Stats().ShutdownReason)
+	assert.Contains(t, logs.PrintMessages, "Shutting down (reason = SIGTERM)")
+	assert.True(t, reader.Closed())
+}
+
+// TestBatchDelivery checks that fetched messages are delivered as a batch and
+// that a PartialBatchError only commits the processed messages.
+func TestBatchDelivery(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Value: []byte("first"), Offset: 1}
+	second := kafka.Message{Value: []byte("second"), Offset: 2}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(first, nil).Once()
+	mockReader.On("FetchMessage", mock.Anything).Return(second, nil)
+	mockReader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100*time.Millisecond).
+		WithBatchDelivery(2, time.Second).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	partialErr := &listener.PartialBatchError{Processed: 1, Err: errors.New("sink is down")} //nolint:goerr113
+	listener := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		batchChan, errChan := listener.BatchAndErrorChannels()
+
+		assert.Equal(t, []kafka.Message{first, second}, <-batchChan)
+		errChan <- nil
+
+		assert.Equal(t, []kafka.Message{second, second}, <-batchChan)
+		errChan <- partialErr
+
+		assert.NoError(t, listener.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, listener.Listen(ctx))
+	mockReader.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{{Offset: 1}, {Offset: 2}})
+	mockReader.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{{Offset: 2}})
+	mockReader.AssertNotCalled(t, "CommitMessages", mock.Anything, []kafka.Message{{Offset: 2}, {Offset: 2}})
+}
+
+// TestBatchByKey checks that the batches are sorted by key and that a PartialBatchError only
+// commits the processed messages preceding every unprocessed one of their partition.
+func TestBatchByKey(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Key: []byte("a"), Offset: 0}
+	second := kafka.Message{Key: []byte("b"), Offset: 1}
+	third := kafka.Message{Key: []byte("a"), Offset: 2}
+	fourth := kafka.Message{Key: []byte("c"), Offset: 3}
+	fifth := kafka.Message{Key: []byte("b"), Offset: 4}
+	reader := kafkotest.NewFakeReader(first, second, third, fourth, fifth)
+
+	opts := listener.NewOptionsListener().
+		WithBatchByKey(3, 100*time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	partialErr := &listener.PartialBatchError{Processed: 2, Err: errors.New("sink is down")} //nolint:goerr113
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		batchChan, errChan := consumer.BatchAndErrorChannels()
+
+		batch := <-batchChan
+		assert.Equal(t, []kafka.Message{first, third, second, fourth}, batch)
+		assert.Equal(t, [][]kafka.Message{{first, third}, {second}, {fourth}}, listener.GroupByKey(batch))
+		errChan <- partialErr
+
+		assert.Equal(t, []kafka.Message{fifth}, <-batchChan)
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 4}}, reader.Committed())
+}
+
+// TestStarted checks that the Started channel is closed once the listener
+// fetched its first message.
+func TestStarted(t *testing.T) {
+	t.Parallel()
+
+	msg := []byte("test message")
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
+	mockReader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener := listener.NewListener(log.NewLogger(), opts)
+
+	select {
+	case <-listener.Started():
+		t.Fatal("the listener cannot be started before Listen")
+	default:
+	}
+
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, listener.Listen(ctx))
+	}()
+
+	select {
+	case <-listener.Started():
+	case <-ctx.Done():
+		t.Fatal("the listener did not start")
+	}
+
+	msgChan, errChan := listener.MessageAndErrorChannels()
+	assert.Equal(t, msg, <-msgChan)
+
+	errChan <- nil
+
+	assert.NoError(t, listener.Shutdown(ctx))
+
+	<-listenerFinished
+}
+
+// TestCommitInFlightOnShutdown checks that, with WithCommitInFlightOnShutdown,
+// Shutdown waits for the in-flight acknowledgement beyond the processing timeout
+// and commits the message.
+func TestCommitInFlightOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	msg := []byte("test message")
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100 * time.Millisecond).
+		WithCommitInFlightOnShutdown().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener := listener.NewListener(log.NewLogger(), opts)
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, listener.Listen(ctx))
+	}()
+
+	msgChan, errChan := listener.MessageAndErrorChannels()
+	assert.Equal(t, msg, <-msgChan)
+
+	go func() {
+		// Acknowledge the in-flight message after the processing timeout.
+		time.Sleep(300 * time.Millisecond)
+
+		errChan <- nil
+	}()
+
+	assert.NoError(t, listener.Shutdown(ctx))
+
+	<-listenerFinished
+
+	mockReader.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{{}})
+}
+
+// TestAcknowledgements checks that Ack commits the message, Nack skips it
+// and Drop hands it to the dropped message handler.
+func TestAcknowledgements(t *testing.T) {
+	t.Parallel()
+
+	msg := []byte("test message")
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{Value: msg}, nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	droppedMessages := 0
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			droppedMessages++
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.Listen(ctx))
+	}()
+
+	msgChan, ackChan := consumer.MessageAndAckChannels()
+
+	assert.Equal(t, msg, <-msgChan)
+	ackChan <- listener.Nack
+
+	assert.Equal(t, msg, <-msgChan)
+	ackChan <- listener.Drop
+
+	assert.Equal(t, msg, <-msgChan)
+	ackChan <- listener.Ack
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+
+	assert.Equal(t, 1, droppedMessages)
+	mockReader.AssertNumberOfCalls(t, "CommitMessages", 1)
+}
+
+// MockOffsetReader is a MockKafkaReader able to report committed offsets.
+type MockOffsetReader struct {
+	MockKafkaReader
+
+	offsets map[int]int64
+}
+
+func (m *MockOffsetReader) CommittedOffsets(ctx context.Context) (map[int]int64, error) {
+	return m.offsets, nil
+}
+
+// TestLogCommittedOffsets checks that the committed offsets are logged before
+// consuming when WithLogCommittedOffsets is set.
+func TestLogCommittedOffsets(t *testing.T) {
+	t.Parallel()
+
+	mockReader := &MockOffsetReader{offsets: map[int]int64{1: 7, 0: 42}}
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, context.DeadlineExceeded)
+	mockReader.On("Close").Return(nil)
+
+	logs := log.NewMockLogger()
+
+	opts := listener.NewOptionsListener().
+		WithLogCommittedOffsets().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(logs, opts)
+
+	offsets, err := consumer.CommittedOffsets(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 42, 1: 7}, offsets)
+
+	assert.Error(t, consumer.Listen(ctx))
+	assert.Equal(t, []string{
+		"resuming from offset 42 on partition 0",
+		"resuming from offset 7 on partition 1",
+	}, logs.PrintMessages)
+}
+
+// TestExportImportOffsets checks that the offsets exported from a listener are resumed from by
+// another listener importing them.
+func TestExportImportOffsets(t *testing.T) {
+	t.Parallel()
+
+	blue := kafkotest.NewFakeReader(kafka.Message{Partition: 0, Offset: 4}, kafka.Message{Partition: 1, Offset: 7})
+	green := kafkotest.NewFakeReader()
+
+	newConsumer := func(reader listener.Reader) *listener.Listener {
+		return listener.NewListener(log.NewLogger(), listener.NewOptionsListener().
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	blueConsumer := newConsumer(blue)
+
+	_, err := blueConsumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+
+	snapshot, err := blueConsumer.ExportOffsets(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, blueConsumer.Shutdown(ctx))
+
+	greenConsumer := newConsumer(green)
+	assert.NoError(t, greenConsumer.ImportOffsets(ctx, snapshot))
+
+	offsets, err := greenConsumer.CommittedOffsets(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 5, 1: 8}, offsets)
+
+	// A reader unable to set the committed offsets can't import them.
+	mockReader := new(MockKafkaReader)
+	assert.ErrorIs(t, newConsumer(mockReader).ImportOffsets(ctx, snapshot), listener.ErrOffsetsUnsupported)
+	assert.Error(t, greenConsumer.ImportOffsets(ctx, []byte("not json")))
+}
+
+// TestListenFunc checks that ListenFunc commits the messages successfully
+// handled and drops the others.
+func TestListenFunc(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{}}).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	droppedMessages := 0
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			droppedMessages++
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	handled := 0
+	secondHandled := make(chan struct{})
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		err := consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+			assert.Equal(t, msg, message)
+
+			handled++
+			if handled == 1 {
+				return nil
+			}
+
+			if handled == 2 {
+				close(secondHandled)
+			}
+
+			return errors.New("cannot handle the message") //nolint:goerr113
+		})
+
+		assert.NoError(t, err)
+	}()
+
+	<-secondHandled
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+
+	assert.GreaterOrEqual(t, droppedMessages, 1)
+	mockReader.AssertNumberOfCalls(t, "CommitMessages", 1)
+}
+
+// TestHeaderRouter checks that ListenFunc dispatches the messages by the value
+// of the routing header and falls back to the given handler.
+func TestHeaderRouter(t *testing.T) {
+	t.Parallel()
+
+	created := kafka.Message{Offset: 1, Headers: []kafka.Header{{Key: "event-type", Value: []byte("created")}}}
+	deleted := kafka.Message{Offset: 2, Headers: []kafka.Header{{Key: "event-type", Value: []byte("deleted")}}}
+	unknown := kafka.Message{Offset: 3}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(created, nil).Once()
+	mockReader.On("FetchMessage", mock.Anything).Return(deleted, nil).Once()
+	mockReader.On("FetchMessage", mock.Anything).Return(unknown, nil).Once()
+	mockReader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, context.DeadlineExceeded)
+	mockReader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	routed := map[string][]int64{}
+	route := func(name string) listener.Handler {
+		return func(ctx context.Context, message kafka.Message) error {
+			routed[name] = append(routed[name], message.Offset)
+
+			return nil
+		}
+	}
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithHeaderRouter("event-type", map[string]listener.Handler{
+			"created": route("created"),
+			"deleted": route("deleted"),
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.ErrorIs(t, consumer.ListenFunc(ctx, route("fallback")), context.DeadlineExceeded)
+	assert.Equal(t, map[string][]int64{
+		"created":  {1},
+		"deleted":  {2},
+		"fallback": {3},
+	}, routed)
+}
+
+// TestHeadersOnly checks that the routes receive the messages without their value, while the dropped
+// message handler receives the whole unrouted message.
+func TestHeadersOnly(t *testing.T) {
+	t.Parallel()
+
+	created := kafka.Message{Offset: 0, Value: []byte("large payload"), Headers: []kafka.Header{{Key: "event-type", Value: []byte("created")}}}
+	unknown := kafka.Message{Offset: 1, Value: []byte("unknown payload")}
+	reader := kafkotest.NewFakeReader(created, unknown)
+	routed := []kafka.Message{}
+
+	var consumer *listener.Listener
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := listener.NewOptionsListener().
+		WithHeadersOnly().
+		WithDropSemantics(listener.DropTerminal).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithHeaderRouter("event-type", map[string]listener.Handler{
+			"created": func(ctx context.Context, message kafka.Message) error {
+				routed = append(routed, message)
+
+				return nil
+			},
+		}).
+		WithProcessDroppedMsg(func(_ context.Context, msg *kafka.Message, log listener.Logger) error {
+			assert.Equal(t, unknown.Value, msg.Value)
+
+			go func() {
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+
+			return nil
+		})
+
+	consumer = listener.NewListener(log.NewLogger(), opts)
+
+	assert.NoError(t, consumer.ListenFunc(ctx, nil))
+	assert.Equal(t, []kafka.Message{{Offset: 0, Headers: created.Headers}}, routed)
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestListenFuncNilHandler checks that ListenFunc refuses a nil handler without a router before fetching.
+func TestListenFuncNilHandler(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.ErrorIs(t, consumer.ListenFunc(ctx, nil), listener.ErrResourceIsNil)
+	assert.Empty(t, reader.Committed())
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestHandlerTimeout checks that the context of a blocked handler is canceled, the timeout counted and
+// the message processed as a timed out message.
+func TestHandlerTimeout(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+	dropped := make(chan error, 1)
+
+	timeouts := new(MockIncrementer)
+	timeouts.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithHandlerTimeout(50 * time.Millisecond).
+		WithMetricHandlerTimeouts(timeouts).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- listener.DropReason(ctx)
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		assert.ErrorIs(t, <-dropped, listener.ErrProcessingTimeout)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(handlerCtx context.Context, message kafka.Message) error {
+		// Block until the handler timeout cancels the context.
+		<-handlerCtx.Done()
+
+		return handlerCtx.Err()
+	}))
+	timeouts.AssertNumberOfCalls(t, "Inc", 1)
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+}
+
+// TestOnTimeout checks that the timeout hook receives the messages whose
+// processing timed out along with the elapsed time.
+func TestOnTimeout(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	mockReader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	timeouts := 0
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			return nil
+		}).
+		WithOnTimeout(func(message kafka.Message, elapsed time.Duration) {
+			timeouts++
+
+			assert.Equal(t, msg, message)
+			assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.ErrorIs(t, consumer.Listen(ctx), context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, timeouts, 2)
+}
+
+// TestSecondaryCommitter checks that the offsets are also committed with the secondary committer once the
+// primary commit succeeded, and that its failures don't fail the commit.
+func TestSecondaryCommitter(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+	mirrored := []kafka.Message{}
+
+	failures := new(MockIncrementer)
+	failures.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithMetricSecondaryCommitErrors(failures).
+		WithSecondaryCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			if msgs[0].Offset == 1 {
+				return errors.New("mirror unavailable")
+			}
+
+			mirrored = append(mirrored, msgs...)
+
+			return nil
+		})).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.NoError(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}))
+	assert.NoError(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}))
+
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, mirrored)
+	failures.AssertNumberOfCalls(t, "Inc", 1)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestCommitter checks that the processed messages are committed through the custom Committer
+// instead of the reader.
+func TestCommitter(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	mockReader.On("Close").Return(nil)
+
+	committed := make(chan []kafka.Message, 1)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			select {
+			case committed <- msgs:
+			default:
+			}
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		assert.Equal(t, []kafka.Message{{}}, <-committed)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	err := consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	})
+
+	assert.NoError(t, err)
+	mockReader.AssertNotCalled(t, "CommitMessages", mock.Anything, mock.Anything)
+}
+
+// TestRecommitJitter checks that the commit loop keeps retrying the uncommitted messages when a jitter is set.
+func TestRecommitJitter(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	mockReader.On("Close").Return(nil)
+
+	errorAtCommitMessages := kafka.NetworkException
+	commits := 0
+	recommitted := make(chan struct{})
+	mutex := &sync.Mutex{}
+
+	opts := listener.NewOptionsListener().
+		WithRecommitInterval(100 * time.Millisecond).
+		WithRecommitJitter(0.5).
+		WithReconnectInterval(5 * time.Second).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			commits++
+			if commits == 1 {
+				return &errorAtCommitMessages
+			}
+
+			if commits == 2 {
+				close(recommitted)
+			}
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		<-recommitted
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	err := consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, ctx.Err())
+}
+
+// TestListenWithReason checks that ListenWithReason tells a shutdown from a canceled context.
+func TestListenWithReason(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+
+	newConsumer := func() *listener.Listener {
+		mockReader := new(MockKafkaReader)
+		mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+		mockReader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+		mockReader.On("Close").Return(nil)
+
+		opts := listener.NewOptionsListener().
+			WithReaderFactory(func() listener.Reader {
+				return mockReader
+			})
+
+		return listener.NewListener(log.NewLogger(), opts)
+	}
+
+	t.Run("shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		consumer := newConsumer()
+
+		go func() {
+			msgChan, errChan := consumer.MessageAndErrorChannels()
+			<-msgChan
+
+			errChan <- nil
+
+			assert.NoError(t, consumer.Shutdown(ctx))
+		}()
+
+		reason, err := consumer.ListenWithReason(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, listener.StopShutdown, reason)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		consumer := newConsumer()
+
+		go func() {
+			msgChan, errChan := consumer.MessageAndErrorChannels()
+			<-msgChan
+
+			cancel()
+
+			errChan <- nil
+		}()
+
+		reason, err := consumer.ListenWithReason(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, listener.StopContextCanceled, reason)
+	})
+}
+
+// TestShutdownCanceledCommit checks that a final commit stopped by the shutdown context isn't logged as an error.
+func TestShutdownCanceledCommit(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+	logger := log.NewMockLogger()
+	processed := make(chan struct{})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			// Every commit lasts until its ctx is done, like against an unresponsive broker.
+			<-ctx.Done()
+
+			return ctx.Err()
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(logger, opts)
+
+	go func() {
+		<-processed
+
+		shutdownCtx, cancelShutdown := context.WithCancel(context.Background())
+		cancelShutdown()
+
+		assert.ErrorIs(t, consumer.Shutdown(shutdownCtx), context.Canceled)
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		close(processed)
+
+		return nil
+	}))
+
+	assert.Eventually(t, func() bool {
+		return reader.Closed()
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, logger.ErrorMessages)
+}
+
+// TestFinalCommitContext checks that the final commit of the commit loop succeeds once the context
+// given to Listen is canceled.
+func TestFinalCommitContext(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+	errorAtCommitMessages := kafka.NetworkException
+	committed := make(chan []kafka.Message, 1)
+	attempts := 0
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			// The first commit fails, so the message is left to the final commit.
+			if attempts++; attempts == 1 {
+				return errorAtCommitMessages
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			committed <- msgs
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// The failed commit stops the listener.
+	assert.ErrorIs(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		cancel()
+
+		return nil
+	}), errorAtCommitMessages)
+
+	select {
+	case msgs := <-committed:
+		assert.Equal(t, []kafka.Message{{Offset: 0}}, msgs)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the final commit didn't succeed")
+	}
+}
+
+// TestShutdownPartialCommit checks that Shutdown commits the uncommitted messages partition by
+// partition and reports the partitions it couldn't commit.
+func TestShutdownPartialCommit(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Topic: "topic", Partition: 0, Offset: 10}
+	second := kafka.Message{Topic: "topic", Partition: 1, Offset: 20}
+	errorAtCommitMessages := kafka.NetworkException
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(first, nil).Once()
+	mockReader.On("FetchMessage", mock.Anything).Return(second, nil)
+	mockReader.On("Close").Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secondFailed := make(chan struct{})
+	secondFailedOnce := &sync.Once{}
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(50 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithCommitter(listener.CommitFunc(func(commitCtx context.Context, msgs ...kafka.Message) error {
+			for _, msg := range msgs {
+				if msg.Partition == second.Partition {
+					secondFailedOnce.Do(func() { close(secondFailed) })
+
+					return &errorAtCommitMessages
+				}
+			}
+
+			// The first partition can only be committed by Shutdown, the only one committing with ctx.
+			if commitCtx != ctx {
+				return &errorAtCommitMessages
+			}
+
+			return nil
+		}))
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+			return nil
+		}))
+	}()
+
+	<-secondFailed
+
+	var partial *listener.PartialCommitError
+
+	err := consumer.Shutdown(ctx)
+	assert.ErrorAs(t, err, &partial)
+	assert.ErrorIs(t, err, &errorAtCommitMessages)
+	assert.Equal(t, map[listener.TopicPartition]int64{{Topic: "topic", Partition: 0}: 10}, partial.Committed)
+	assert.Equal(t, map[listener.TopicPartition]int64{{Topic: "topic", Partition: 1}: 20}, partial.Uncommitted)
+
+	// The final commit of the commit loop failed as well, so it's reported too.
+	assert.Contains(t, err.Error(), "commit loop")
+
+	<-listenerFinished
+}
+
+// TestOrderedConcurrency checks that the messages processed concurrently are committed in
+// fetch order, even when their processing completes out of order.
+func TestOrderedConcurrency(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	messages := []kafka.Message{{Offset: 0}, {Offset: 1}, {Offset: 2}}
+
+	mockReader := new(MockKafkaReader)
+	for _, msg := range messages {
+		mockReader.On("FetchMessage", mock.Anything).Return(msg, nil).Once()
+	}
+
+	mockReader.On("FetchMessage", mock.Anything).Run(func(args mock.Arguments) {
+		ctx, _ := args.Get(0).(context.Context)
+		<-ctx.Done()
+	}).Return(kafka.Message{}, context.Canceled)
+	mockReader.On("Close").Return(nil)
+
+	mutex := &sync.Mutex{}
+	committed := []kafka.Message{}
+	allCommitted := make(chan struct{})
+
+	opts := listener.NewOptionsListener().
+		WithOrderedConcurrency(3).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			committed = append(committed, msgs...)
+			if len(committed) == len(messages) {
+				close(allCommitted)
+			}
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	releaseFirst := make(chan struct{})
+	othersDone := &sync.WaitGroup{}
+	listenerFinished := make(chan struct{})
+
+	othersDone.Add(len(messages) - 1)
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+			if message.Offset == 0 {
+				<-releaseFirst
+
+				return nil
+			}
+
+			othersDone.Done()
+
+			return nil
+		}))
+	}()
+
+	// The later messages completed, but they can't be committed before the first one.
+	othersDone.Wait()
+
+	mutex.Lock()
+	assert.Empty(t, committed)
+	mutex.Unlock()
+
+	close(releaseFirst)
+	<-allCommitted
+
+	assert.Equal(t, messages, committed)
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+}
+
+// TestProcessingSemaphore checks that the messages processed at once are capped across the workers
+// of ListenFunc and the unacknowledged messages of Next.
+func TestProcessingSemaphore(t *testing.T) {
+	t.Parallel()
+
+	messages := make([]kafka.Message, 20)
+	for i := range messages {
+		messages[i] = kafka.Message{Offset: int64(i)}
+	}
+
+	reader := kafkotest.NewFakeReader(messages...)
+
+	opts := listener.NewOptionsListener().
+		WithOrderedConcurrency(8).
+		WithProcessingSemaphore(2).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	var inFlight, maxInFlight, total int32
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			highest := atomic.LoadInt32(&maxInFlight)
+			if current <= highest || atomic.CompareAndSwapInt32(&maxInFlight, highest, current) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		if atomic.AddInt32(&total, 1) == int32(len(messages)) {
+			go func() {
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+		}
+
+		return nil
+	}))
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+
+	// Next holds the slot until the message is acknowledged.
+	reader = kafkotest.NewFakeReader(messages[:2]...)
+	consumer = listener.NewListener(log.NewLogger(), opts.WithProcessingSemaphore(1))
+
+	_, ack, err := consumer.Next(ctx)
+	assert.NoError(t, err)
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+
+	_, _, err = consumer.Next(shortCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ack(nil)
+
+	_, ack, err = consumer.Next(ctx)
+	assert.NoError(t, err)
+
+	ack(nil)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestValidate checks that the incompatible options are refused, by NewListener too.
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts *listener.OptionsListener
+		err  error
+	}{
+		{"Valid", listener.NewOptionsListener().WithOrderedConcurrency(2).WithKeyAffinity(), nil},
+		{"KeyAffinityWithoutConcurrency", listener.NewOptionsListener().WithKeyAffinity(), listener.ErrIncompatibleOptions},
+		{"CheckpointOnShutdownOnly", listener.NewOptionsListener().WithCheckpointOnAssign().WithCommitOnShutdownOnly(), listener.ErrIncompatibleOptions},
+		{"HealthProbeWithPrefetch", listener.NewOptionsListener().WithReaderHealthProbe(time.Second).WithPrefetch(10), listener.ErrIncompatibleOptions},
+		{"CommitBeforeProcessOnShutdownOnly", listener.NewOptionsListener().WithCommitMode(listener.CommitBeforeProcess).WithCommitOnShutdownOnly(), listener.ErrIncompatibleOptions},
+		{"BatchByKeyWithBatchDelivery", listener.NewOptionsListener().WithBatchByKey(10, time.Second).WithBatchDelivery(10, time.Second), listener.ErrIncompatibleOptions},
+		{"UnsupportedCompression", listener.NewOptionsListener().WithExpectedCompression(kafka.Compression(9)), listener.ErrUnsupportedCompression},
+		{"JitterWithoutRecommitLoop", listener.NewOptionsListener().WithRecommitJitter(0.1).WithoutRecommitLoop(), listener.ErrIncompatibleOptions},
+		{"UncommittedWarnWithoutRecommitLoop", listener.NewOptionsListener().WithUncommittedWarnThreshold(10).WithoutRecommitLoop(), listener.ErrIncompatibleOptions},
+		{"BatchDeliveryWithMaxMessageSize", listener.NewOptionsListener().WithBatchDelivery(10, time.Second).WithMaxMessageSize(1024), listener.ErrIncompatibleOptions},
+		{"BatchByKeyWithTimeoutHeader", listener.NewOptionsListener().WithBatchByKey(10, time.Second).WithTimeoutHeader("x-timeout-ms", time.Minute), listener.ErrIncompatibleOptions},
+		{"StaticMembership", listener.NewOptionsListener().WithConsumerGroupInstanceID("orders-0"), listener.ErrStaticMembershipUnsupported},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.opts.Validate()
+			if test.err == nil {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Panics(t, func() {
+				listener.NewListener(log.NewLogger(), test.opts)
+			})
+		})
+	}
+}
+
+// TestRebalanceOptionsRequireGenerationReader checks that NewListener refuses the options relying on
+// GenerationReader with a *kafka.Reader, which doesn't implement it.
+func TestRebalanceOptionsRequireGenerationReader(t *testing.T) {
+	t.Parallel()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"localhost:9092"}, Topic: "orders"})
+	defer reader.Close()
+
+	for _, opts := range []*listener.OptionsListener{
+		listener.NewOptionsListener().WithOnPartitionsRevoked(func([]listener.TopicPartition) {}),
+		listener.NewOptionsListener().WithOnPartitionsAssigned(func([]listener.TopicPartition) {}),
+		listener.NewOptionsListener().WithMetricRebalances(new(MockIncrementer)),
+	} {
+		assert.Panics(t, func() {
+			listener.NewListener(log.NewLogger(), listener.NewOptionsListener().
+				WithReaderFactory(func() listener.Reader {
+					return reader
+				}), opts)
+		})
+
+		// The fake reader implements GenerationReader.
+		assert.NotPanics(t, func() {
+			listener.NewListener(log.NewLogger(), opts.WithReaderFactory(func() listener.Reader {
+				return kafkotest.NewFakeReader()
+			}))
+		})
+	}
+}
+
+// TestKeyAffinity checks that the messages with the same key are processed one at a time and in fetch
+// order under concurrent load, while all of them are committed in fetch order.
+func TestKeyAffinity(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{"a", "b", "c", "d"}
+	messages := make([]kafka.Message, 200)
+
+	for i := range messages {
+		messages[i] = kafka.Message{Offset: int64(i), Key: []byte(keys[i%len(keys)])}
+	}
+
+	reader := kafkotest.NewFakeReader(messages...)
+
+	opts := listener.NewOptionsListener().
+		WithOrderedConcurrency(8).
+		WithKeyAffinity().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	mutex := &sync.Mutex{}
+	inFlight := map[string]int{}
+	processed := map[string][]int64{}
+	total := 0
+	done := make(chan struct{})
+
+	go func() {
+		<-done
+
+		assert.Eventually(t, func() bool {
+			return len(reader.Committed()) > 0 && reader.Committed()[len(reader.Committed())-1].Offset == int64(len(messages)-1)
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		key := string(message.Key)
+
+		mutex.Lock()
+		inFlight[key]++
+		assert.Equal(t, 1, inFlight[key], "key %s processed concurrently", key)
+		mutex.Unlock()
+
+		time.Sleep(time.Duration(message.Offset%3) * time.Millisecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		inFlight[key]--
+		processed[key] = append(processed[key], message.Offset)
+
+		if total++; total == len(messages) {
+			close(done)
+		}
+
+		return nil
+	}))
+
+	for _, key := range keys {
+		assert.Len(t, processed[key], len(messages)/len(keys))
+		assert.IsIncreasing(t, processed[key])
+	}
+
+	committed := reader.Committed()
+	for i := 1; i < len(committed); i++ {
+		assert.Less(t, committed[i-1].Offset, committed[i].Offset)
+	}
+}
+
+type MockGauge struct {
+	mock.Mock
+}
+
+func (m *MockGauge) Set(value float64) {
+	m.Called(value)
+}
+
+// TestLastCommittedTime checks that the time of the newest committed message is exposed
+// through Stats and the gauge.
+func TestLastCommittedTime(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message"), Time: time.Unix(1700000000, 0)}
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	mockReader.On("CommitMessages", mock.Anything, []kafka.Message{{Time: msg.Time}}).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	committed := make(chan struct{})
+	committedOnce := &sync.Once{}
+
+	gauge := new(MockGauge)
+	gauge.On("Set", float64(1700000000)).Run(func(mock.Arguments) {
+		committedOnce.Do(func() { close(committed) })
+	})
+
+	opts := listener.NewOptionsListener().
+		WithMetricLastCommittedTime(gauge).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.True(t, consumer.Stats().LastCommittedTime.IsZero())
+
+	go func() {
+		<-committed
+
+		assert.Equal(t, msg.Time, consumer.Stats().LastCommittedTime)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}))
+}
+
+// TestStatsGoroutines checks that the goroutines of the listener don't grow with the throughput
+// and are all gone once it's shut down.
+func TestStatsGoroutines(t *testing.T) {
+	t.Parallel()
+
+	messages := make([]kafka.Message, 50)
+	for i := range messages {
+		messages[i] = kafka.Message{Offset: int64(i)}
+	}
+
+	reader := kafkotest.NewFakeReader(messages...)
+
+	opts := listener.NewOptionsListener().
+		WithOrderedConcurrency(2).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	processed := int32(0)
+	done := make(chan struct{})
+
+	go func() {
+		<-done
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		// The context watcher, the commit loop and up to two workers.
+		assert.LessOrEqual(t, consumer.Stats().Goroutines, 4)
+
+		if atomic.AddInt32(&processed, 1) == int32(len(messages)) {
+			close(done)
+		}
+
+		return nil
+	}))
+
+	assert.Eventually(t, func() bool {
+		return consumer.Stats().Goroutines == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestConsumeN checks that ConsumeN fetches and commits exactly the requested number of messages.
+func TestConsumeN(t *testing.T) {
+	t.Parallel()
+
+	messages := []kafka.Message{{Offset: 0}, {Offset: 1}, {Offset: 2}}
+
+	mockReader := new(MockKafkaReader)
+	for _, msg := range messages {
+		mockReader.On("FetchMessage", mock.Anything).Return(msg, nil).Once()
+	}
+
+	mockReader.On("CommitMessages", mock.Anything, messages[:2]).Return(nil)
+	mockReader.On("Close").Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	consumed, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, messages[:2], consumed)
+	mockReader.AssertNumberOfCalls(t, "FetchMessage", 2)
+	mockReader.AssertNumberOfCalls(t, "CommitMessages", 1)
+
+	// Nothing is fetched for zero messages and a negative count is refused.
+	consumed, err = consumer.ConsumeN(ctx, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, consumed)
+
+	_, err = consumer.ConsumeN(ctx, -1)
+	assert.ErrorIs(t, err, listener.ErrInvalidCount)
+	mockReader.AssertNumberOfCalls(t, "FetchMessage", 2)
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	_, err = consumer.ConsumeN(ctx, 1)
+	assert.ErrorIs(t, err, listener.ErrShuttingDown)
+}
+
+// TestProcessOnce checks that ProcessOnce processes exactly one message per call, committing it
+// or handing it to the dropped message handler depending on the handler's result.
+func TestProcessOnce(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+	dropped := []int64{}
+
+	opts := listener.NewOptionsListener().
+		WithDropSemantics(listener.DropRedeliver).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped = append(dropped, msg.Offset)
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// A nil handler is refused before fetching.
+	assert.ErrorIs(t, consumer.ProcessOnce(ctx, nil), listener.ErrResourceIsNil)
+
+	assert.NoError(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}))
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+
+	assert.NoError(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return errors.New("processing failed")
+	}))
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+	assert.Empty(t, dropped)
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+	assert.ErrorIs(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}), listener.ErrShuttingDown)
+}
+
+// TestDrain checks that Drain commits the processed messages and stops the fetching, even while a fetch
+// waits for a message, until Resume.
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+	handled := make(chan int64, 2)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		assert.Equal(t, int64(0), <-handled)
+
+		// The fetch of the next message is waiting, as there's none.
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, consumer.Drain(ctx))
+		assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+
+		reader.Push(kafka.Message{Offset: 1})
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, handled)
+
+		consumer.Resume()
+		assert.Equal(t, int64(1), <-handled)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		handled <- message.Offset
+
+		return nil
+	}))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestDrainWithPrefetch checks that Drain doesn't wait for the prefetching, which only stops with the Listen.
+func TestDrainWithPrefetch(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+	handled := make(chan int64, 2)
+
+	opts := listener.NewOptionsListener().
+		WithPrefetch(2).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		assert.Equal(t, int64(0), <-handled)
+
+		drainCtx, cancelDrain := context.WithTimeout(ctx, time.Second)
+		defer cancelDrain()
+
+		assert.NoError(t, consumer.Drain(drainCtx))
+		assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+
+		reader.Push(kafka.Message{Offset: 1})
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, handled)
+
+		consumer.Resume()
+		assert.Equal(t, int64(1), <-handled)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		handled <- message.Offset
+
+		return nil
+	}))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestIdleFor checks that the idle time is measured from the creation and then from the last fetch.
+func TestIdleFor(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.GreaterOrEqual(t, consumer.IdleFor(), 100*time.Millisecond)
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+	assert.Less(t, consumer.IdleFor(), 100*time.Millisecond)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestIdleForBatch checks that in batch mode the batch windows elapsing on an idle topic don't reset IdleFor.
+func TestIdleForBatch(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader()
+
+	opts := listener.NewOptionsListener().
+		WithBatchDelivery(10, 10*time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.GreaterOrEqual(t, consumer.IdleFor(), 100*time.Millisecond)
+
+		reader.Push(kafka.Message{Offset: 0})
+
+		batchChan, errChan := consumer.BatchAndErrorChannels()
+		<-batchChan
+		errChan <- nil
+
+		assert.Less(t, consumer.IdleFor(), 100*time.Millisecond)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+}
+
+// TestCommitLoopDuringReconnect checks that the commit loop retries to commit through the reader
+// while the failed fetches replace it.
+func TestCommitLoopDuringReconnect(t *testing.T) {
+	t.Parallel()
+
+	errorAtKafka := kafka.NetworkException
+	errs := make([]error, 10)
+
+	for i := range errs {
+		errs[i] = &errorAtKafka
+	}
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}).FailCommit(errs...)
+
+	opts := listener.NewOptionsListener().
+		WithRecommitInterval(time.Millisecond).
+		WithReconnectInterval(time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	done := make(chan struct{})
+
+	go func() {
+		<-done
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		if message.Offset > 0 {
+			close(done)
+
+			return nil
+		}
+
+		// The commit loop retries to commit the first message meanwhile.
+		reader.FailFetch(errs...).Push(kafka.Message{Offset: 1})
+
+		return nil
+	}))
+
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestFlush checks that Flush commits the messages left uncommitted by a failed commit.
+func TestFlush(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+	errorAtCommitMessages := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(msg).FailCommit(&errorAtCommitMessages)
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// The commit fails with a recoverable error, so the message stays uncommitted.
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, reader.Committed())
+
+	assert.NoError(t, consumer.Flush(ctx))
+	assert.Equal(t, []kafka.Message{{}}, reader.Committed())
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestWithoutRecommitLoop checks that a zero recommit interval disables the commit loop
+// instead of panicking, while the processed messages are still committed.
+func TestWithoutRecommitLoop(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+	reader := kafkotest.NewFakeReader(msg)
+
+	opts := listener.NewOptionsListener().
+		WithRecommitInterval(0).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+		assert.Equal(t, msg.Value, <-msgChan)
+
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{}}, reader.Committed())
+}
+
+type MockIncrementer struct {
+	mock.Mock
+}
+
+func (m *MockIncrementer) Inc() {
+	m.Called()
+}
+
+type MockAdder struct {
+	mock.Mock
+}
+
+func (m *MockAdder) Add(value float64) {
+	m.Called(value)
+}
+
+type MockDuration struct {
+	mock.Mock
+}
+
+func (m *MockDuration) Observe(value float64) {
+	m.Called(value)
+}
+
+// TestCommitOnShutdownOnly checks that the processed messages are only committed by Shutdown,
+// keeping the highest offset of every partition.
+func TestCommitOnShutdownOnly(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Partition: 0, Offset: 0},
+		kafka.Message{Partition: 1, Offset: 0},
+		kafka.Message{Partition: 0, Offset: 1},
+	)
+
+	opts := listener.NewOptionsListener().
+		WithCommitOnShutdownOnly().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, reader.Committed())
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+	assert.Equal(t, []kafka.Message{{Partition: 0, Offset: 1}, {Partition: 1, Offset: 0}}, reader.Committed())
+}
+
+// lockedLogger is a MockLogger safe to share with the goroutines of the Listener.
+type lockedLogger struct {
+	mutex sync.Mutex
+	log   *log.MockLogger
+}
+
+func (l *lockedLogger) Printf(format string, v ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.log.Printf(format, v...)
+}
+
+func (l *lockedLogger) Panicf(err error, format string, v ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.log.Panicf(err, format, v...)
+}
+
+func (l *lockedLogger) Errorf(err error, format string, v ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.log.Errorf(err, format, v...)
+}
+
+// TestUncommittedDepth checks that the commit loop samples the highest number of uncommitted messages
+// since the last commit and warns about it once it exceeds the threshold.
+func TestUncommittedDepth(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Partition: 0, Offset: 0},
+		kafka.Message{Partition: 1, Offset: 0},
+		kafka.Message{Partition: 2, Offset: 0},
+	)
+	logs := &lockedLogger{log: log.NewMockLogger()}
+	samples := make(chan float64, 16)
+
+	gauge := new(MockGauge)
+	gauge.On("Set", mock.Anything).Run(func(args mock.Arguments) {
+		select {
+		case samples <- args.Get(0).(float64):
+		default:
+		}
+	}).Return()
+
+	// The commits stall until the shutdown, so the messages pile up between the ticks of the commit loop.
+	var stalled int32 = 1
+
+	opts := listener.NewOptionsListener().
+		WithOrderedConcurrency(2).
+		WithRecommitInterval(10 * time.Millisecond).
+		WithMetricUncommittedDepth(gauge).
+		WithUncommittedWarnThreshold(2).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			if atomic.LoadInt32(&stalled) == 1 {
+				return context.DeadlineExceeded
+			}
+
+			return reader.CommitMessages(ctx, msgs...)
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(logs, opts)
+
+	var handled int32
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(_ context.Context, message kafka.Message) error {
+		if atomic.AddInt32(&handled, 1) < 3 {
+			return nil
+		}
+
+		// The third message is added as uncommitted once handled, so wait for the sample after it.
+		go func() {
+			for sample := range samples {
+				if sample == 3 {
+					break
+				}
+			}
+
+			atomic.StoreInt32(&stalled, 0)
+			assert.NoError(t, consumer.Shutdown(ctx))
+		}()
+
+		return nil
+	}))
+
+	// Let the commit loop finish logging before reading the logs.
+	assert.Eventually(t, func() bool {
+		return consumer.Stats().Goroutines == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, logs.log.PrintMessages, "Warning: 3 messages left uncommitted since the last commit (threshold = 2), the commits may be stalled")
+}
+
+// TestCommitBeforeProcess checks that, with CommitBeforeProcess, a message is committed before its
+// delivery and isn't committed again once acknowledged, even if it failed.
+func TestCommitBeforeProcess(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0, Value: []byte("low-value message")})
+
+	opts := listener.NewOptionsListener().
+		WithCommitMode(listener.CommitBeforeProcess).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		assert.Equal(t, []byte("low-value message"), <-msgChan)
+		assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+		errChan <- errors.New("processing failed") //nolint:goerr113
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+}
+
+// TestCommittedOffsets checks that FlushWithOffsets and ShutdownWithOffsets return the highest
+// offset they committed for every partition.
+func TestCommittedOffsets(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Partition: 0, Offset: 0},
+		kafka.Message{Partition: 1, Offset: 0},
+		kafka.Message{Partition: 0, Offset: 1},
+	)
+
+	opts := listener.NewOptionsListener().
+		WithCommitOnShutdownOnly().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 3)
+	assert.NoError(t, err)
+
+	committed, err := consumer.FlushWithOffsets(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[listener.TopicPartition]int64{{Partition: 0}: 1, {Partition: 1}: 0}, committed)
+
+	reader.Push(kafka.Message{Partition: 1, Offset: 1})
+
+	_, err = consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	committed, err = consumer.ShutdownWithOffsets(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[listener.TopicPartition]int64{{Partition: 1}: 1}, committed)
+}
+
+// TestCommittedOffsetsDuringReconnect checks that CommittedOffsets and ExportOffsets read the reader
+// while the failed fetches replace it.
+func TestCommittedOffsetsDuringReconnect(t *testing.T) {
+	t.Parallel()
+
+	errorAtFetchMessage := kafka.NetworkException
+	errs := make([]error, 20)
+
+	for i := range errs {
+		errs[i] = &errorAtFetchMessage
+	}
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}).FailFetch(errs...)
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-consumer.Started():
+				return
+			default:
+			}
+
+			_, err := consumer.CommittedOffsets(ctx)
+			assert.NoError(t, err)
+
+			_, err = consumer.ExportOffsets(ctx)
+			assert.NoError(t, err)
+		}
+	}()
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	<-done
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestUnbufferedAck checks that the acknowledgement sent after the processing of a message timed
+// out is discarded instead of being attributed to the next message.
+func TestUnbufferedAck(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Offset: 0, Value: []byte("first message")}
+	second := kafka.Message{Offset: 1, Value: []byte("second message")}
+	reader := kafkotest.NewFakeReader(first, second)
+	dropped := make(chan kafka.Message, 1)
+
+	opts := listener.NewOptionsListener().
+		WithUnbufferedAck().
+		WithProcessingTimeout(100 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- *msg
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, ackChan := consumer.MessageAndAckChannels()
+
+		assert.Equal(t, first.Value, <-msgChan)
+		assert.Equal(t, first, <-dropped)
+
+		// Acknowledge the first message once it's dropped, which must not commit the second one.
+		// The first one is committed as the dropped message handler succeeded.
+		ackChan <- listener.Ack
+
+		assert.Equal(t, second.Value, <-msgChan)
+
+		ackChan <- listener.Nack
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Offset: first.Offset}}, reader.Committed())
+}
+
+// TestReconnectMetrics checks that the reconnections after a recoverable error are measured
+// and reported by Stats.
+func TestReconnectMetrics(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Value: []byte("test message")}
+	errorAtFetchMessage := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(msg).FailFetch(&errorAtFetchMessage)
+
+	duration := new(MockDuration)
+	duration.On("Observe", mock.Anything).Return()
+
+	successes := new(MockIncrementer)
+	successes.On("Inc").Return()
+
+	failures := new(MockIncrementer)
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10*time.Millisecond).
+		WithMetricReconnects(duration, successes, failures).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	start := time.Now()
+
+	assert.Zero(t, consumer.Stats().Reconnects)
+	assert.True(t, consumer.Stats().LastReconnect.IsZero())
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	duration.AssertNumberOfCalls(t, "Observe", 1)
+	successes.AssertNumberOfCalls(t, "Inc", 1)
+	failures.AssertNotCalled(t, "Inc")
+	assert.Equal(t, 1, consumer.Stats().Reconnects)
+	assert.False(t, consumer.Stats().LastReconnect.Before(start))
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+type MockOffsetStore struct {
+	mock.Mock
+}
+
+func (m *MockOffsetStore) Load(ctx context.Context, partition listener.TopicPartition) (int64, error) {
+	args := m.Called(ctx, partition)
+	offset, ok := args.Get(0).(int64)
+
+	if !ok {
+		panic("cannot cast")
+	}
+
+	return offset, args.Error(1)
+}
+
+func (m *MockOffsetStore) Save(ctx context.Context, partition listener.TopicPartition, offset int64) error {
+	args := m.Called(ctx, partition, offset)
+
+	return args.Error(0)
+}
+
+// TestPartitionListener checks that the partition listener resumes from the offset of the store
+// and saves there the offsets of the processed messages.
+func TestPartitionListener(t *testing.T) {
+	t.Parallel()
+
+	config := kafka.ReaderConfig{Topic: "topic", Partition: 3, Brokers: []string{"localhost:9092"}}
+	partition := listener.TopicPartition{Topic: "topic", Partition: 3}
+
+	t.Run("resumes from the stored offset", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		store := new(MockOffsetStore)
+		store.On("Load", mock.Anything, partition).Return(int64(42), nil)
+
+		consumer := listener.NewPartitionListener(log.NewLogger(), config, store)
+
+		store.AssertCalled(t, "Load", mock.Anything, partition)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	})
+
+	t.Run("saves the processed offsets", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		messages := []kafka.Message{{Topic: "topic", Partition: 3, Offset: 42}, {Topic: "topic", Partition: 3, Offset: 43}}
+		reader := kafkotest.NewFakeReader(messages...)
+
+		store := new(MockOffsetStore)
+		store.On("Save", mock.Anything, partition, int64(44)).Return(nil)
+
+		opts := listener.NewOptionsListener().
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			})
+
+		consumer := listener.NewPartitionListener(log.NewLogger(), config, store, opts)
+
+		_, err := consumer.ConsumeN(ctx, len(messages))
+		assert.NoError(t, err)
+		store.AssertCalled(t, "Save", mock.Anything, partition, int64(44))
+		assert.Empty(t, reader.Committed())
+		assert.NoError(t, consumer.Shutdown(ctx))
+	})
+}
+
+// TestOutcomeHook checks that the outcome hook records the fate of every message.
+func TestOutcomeHook(t *testing.T) {
+	t.Parallel()
+
+	headers := []kafka.Header{{Key: "trace-id", Value: []byte("abc")}}
+	messages := []kafka.Message{
+		{Offset: 0, Key: []byte("first"), Value: []byte("value"), Headers: headers},
+		{Offset: 1, Key: []byte("second")},
+	}
+	reader := kafkotest.NewFakeReader(messages...)
+
+	mutex := &sync.Mutex{}
+	outcomes := map[int64]listener.Outcome{}
+	committed := []kafka.Message{}
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			return nil
+		}).
+		WithOutcomeHook(func(message kafka.Message, outcome listener.Outcome) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			outcomes[message.Offset] = outcome
+
+			if outcome == listener.OutcomeCommitted {
+				committed = append(committed, message)
+			}
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, ackChan := consumer.MessageAndAckChannels()
+
+		<-msgChan
+		ackChan <- listener.Ack
+
+		<-msgChan
+		ackChan <- listener.Drop
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, map[int64]listener.Outcome{0: listener.OutcomeCommitted, 1: listener.OutcomeDropped}, outcomes)
+
+	// The committed messages keep their key and headers for the audit, but the committer only gets their offset.
+	assert.Equal(t, []kafka.Message{{Offset: 0, Key: []byte("first"), Headers: headers}}, committed)
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+}
+
+// TestOnMessage checks that the message hook observes every delivered message and that its
+// panics don't affect the commit of the messages.
+func TestOnMessage(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Offset: 0, Value: []byte("first")}
+	second := kafka.Message{Offset: 1, Value: []byte("second")}
+	reader := kafkotest.NewFakeReader(first, second)
+	logs := log.NewMockLogger()
+	observed := []kafka.Message{}
+
+	opts := listener.NewOptionsListener().
+		WithOnMessage(func(message kafka.Message) {
+			observed = append(observed, message)
+
+			if message.Offset == 0 {
+				panic("observer failed")
+			}
+		}).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(logs, opts)
+
+	_, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kafka.Message{first, second}, observed)
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+	assert.Len(t, logs.ErrorMessages, 1)
+	assert.Contains(t, logs.ErrorMessages[0], "Recovered from a panic of the message hook (partition = 0, offset = 0)")
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestMetricBytesConsumed checks that the bytes of the values of the delivered messages are counted.
+func TestMetricBytesConsumed(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0, Value: []byte("first")}, kafka.Message{Offset: 1})
+
+	consumed := new(MockAdder)
+	consumed.On("Add", mock.Anything).Return()
+
+	opts := listener.NewOptionsListener().
+		WithMetricBytesConsumed(consumed).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+	consumed.AssertCalled(t, "Add", float64(5))
+	consumed.AssertCalled(t, "Add", float64(0))
+	consumed.AssertNumberOfCalls(t, "Add", 2)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestOnMessageAfterSizeLimit checks that the messages dropped by WithMaxMessageSize are neither observed
+// nor counted as consumed, in both delivery modes.
+func TestOnMessageAfterSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	oversized := kafka.Message{Offset: 0, Value: []byte("oversized")}
+	accepted := kafka.Message{Offset: 1, Value: []byte("ok")}
+
+	newConsumer := func() (*listener.Listener, *[]int64, *MockAdder) {
+		reader := kafkotest.NewFakeReader(oversized, accepted)
+		observed := []int64{}
+
+		consumed := new(MockAdder)
+		consumed.On("Add", mock.Anything).Return()
+
+		opts := listener.NewOptionsListener().
+			WithMaxMessageSize(4).
+			WithMetricBytesConsumed(consumed).
+			WithOnMessage(func(message kafka.Message) {
+				observed = append(observed, message.Offset)
+			}).
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			}).
+			WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+				return nil
+			})
+
+		return listener.NewListener(log.NewLogger(), opts), &observed, consumed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer, observed, consumed := newConsumer()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		go func() {
+			assert.NoError(t, consumer.Shutdown(ctx))
+		}()
+
+		return nil
+	}))
+	assert.Equal(t, []int64{1}, *observed)
+	consumed.AssertCalled(t, "Add", float64(2))
+	consumed.AssertNumberOfCalls(t, "Add", 1)
+
+	consumer, observed, consumed = newConsumer()
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		<-msgChan
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []int64{1}, *observed)
+	consumed.AssertNumberOfCalls(t, "Add", 1)
+}
+
+// TestDeadlineFromMessageTime checks that a message past its deadline is dropped and committed, and
+// that the handler's context of the others expires at their deadline.
+func TestDeadlineFromMessageTime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Offset: 0, Time: now.Add(-2 * time.Second)},
+		kafka.Message{Offset: 1, Time: now.Add(-500 * time.Millisecond)},
+	)
+	dropped := make(chan int64, 2)
+
+	opts := listener.NewOptionsListener().
+		WithDeadlineFromMessageTime(time.Second).
+		WithClock(func() time.Time {
+			return now
+		}).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Offset
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(handlerCtx context.Context, message kafka.Message) error {
+		assert.Equal(t, int64(1), message.Offset)
+
+		deadline, ok := handlerCtx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(500*time.Millisecond), deadline, 100*time.Millisecond)
+
+		go func() {
+			assert.NoError(t, consumer.Shutdown(ctx))
+		}()
+
+		return nil
+	}))
+	assert.Equal(t, int64(0), <-dropped)
+	assert.Empty(t, dropped)
+
+	committed := reader.Committed()
+	if assert.Len(t, committed, 2) {
+		assert.Equal(t, int64(0), committed[0].Offset)
+		assert.Equal(t, int64(1), committed[1].Offset)
+	}
+}
+
+// TestTimeoutHeader checks that a message extends its processing timeout through the timeout header.
+func TestTimeoutHeader(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{
+		Value:   []byte("test message"),
+		Headers: []kafka.Header{{Key: "x-process-timeout-ms", Value: []byte("500")}},
+	}
+	reader := kafkotest.NewFakeReader(msg)
+	droppedMessages := 0
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100*time.Millisecond).
+		WithTimeoutHeader("x-process-timeout-ms", time.Second).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			droppedMessages++
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+		<-msgChan
+
+		// Slower than the processing timeout, but faster than the one of the header.
+		time.Sleep(200 * time.Millisecond)
+
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{}}, reader.Committed())
+	assert.Zero(t, droppedMessages)
+}
+
+// TestStartedBatchIdle checks that in batch mode, like in single-message mode, Started isn't closed
+// by the batch windows that elapse on an idle topic, only by the first fetched message.
+func TestStartedBatchIdle(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader()
+
+	opts := listener.NewOptionsListener().
+		WithBatchDelivery(10, 10*time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		select {
+		case <-consumer.Started():
+			assert.Fail(t, "started on an idle topic")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		reader.Push(kafka.Message{Offset: 0})
+
+		batchChan, errChan := consumer.BatchAndErrorChannels()
+		<-batchChan
+		errChan <- nil
+
+		<-consumer.Started()
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+}
+
+// TestAlreadyListening checks that a second Listen is rejected while the first one runs.
+func TestAlreadyListening(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	listenerFinished := make(chan struct{})
+
+	go func() {
+		defer close(listenerFinished)
+
+		assert.NoError(t, consumer.Listen(ctx))
+	}()
+
+	<-consumer.Started()
+
+	assert.ErrorIs(t, consumer.Listen(ctx), listener.ErrAlreadyListening)
+	assert.ErrorIs(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}), listener.ErrAlreadyListening)
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.ErrorIs(t, err, listener.ErrAlreadyListening)
+
+	_, _, err = consumer.Next(ctx)
+	assert.ErrorIs(t, err, listener.ErrAlreadyListening)
+
+	msgChan, errChan := consumer.MessageAndErrorChannels()
+	<-msgChan
+
+	errChan <- nil
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	<-listenerFinished
+}
+
+// TestListenAgainAfterError checks that a Listen stopped by an error stops its goroutines, so the
+// next Listen doesn't run a second commit loop or prefetcher against the same reader.
+func TestListenAgainAfterError(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1},
+		kafka.Message{Offset: 2}, kafka.Message{Offset: 3})
+	errorAtCommitMessages := kafka.NetworkException
+
+	opts := listener.NewOptionsListener().
+		WithPrefetch(1).
+		WithUncommittedWarnThreshold(10).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			return errorAtCommitMessages
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	handler := func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}
+
+	for run := 0; run < 2; run++ {
+		assert.ErrorIs(t, consumer.ListenFunc(ctx, handler), errorAtCommitMessages)
+
+		assert.Eventually(t, func() bool {
+			return consumer.Stats().Goroutines == 0
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+// TestSkipEmptyMessages checks that a zero-value message is skipped instead of delivered.
+func TestSkipEmptyMessages(t *testing.T) {
+	t.Parallel()
+
+	empty := kafka.Message{Offset: 0}
+	msg := kafka.Message{Offset: 1, Value: []byte("test message")}
+	reader := kafkotest.NewFakeReader(empty, msg)
+	filtered := []kafka.Message{}
+
+	opts := listener.NewOptionsListener().
+		WithSkipEmptyMessages(true).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithOutcomeHook(func(message kafka.Message, outcome listener.Outcome) {
+			if outcome == listener.OutcomeFiltered {
+				filtered = append(filtered, message)
+			}
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+		assert.Equal(t, msg.Value, <-msgChan)
+
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{empty}, filtered)
+	assert.Equal(t, []kafka.Message{{Offset: 1}}, reader.Committed())
+}
+
+// TestAdaptiveThrottle checks that the fetches are delayed while the heap exceeds the threshold
+// and that the delay doesn't outlive the context.
+func TestAdaptiveThrottle(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Delayed", func(t *testing.T) {
+		t.Parallel()
+
+		reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+		logs := log.NewMockLogger()
+
+		opts := listener.NewOptionsListener().
+			WithAdaptiveThrottle(1).
+			WithThrottleDelay(50 * time.Millisecond).
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		consumer := listener.NewListener(logs, opts)
+
+		start := time.Now()
+		_, err := consumer.ConsumeN(ctx, 2)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+		assert.NotEmpty(t, logs.PrintMessages)
+		assert.True(t, strings.HasPrefix(logs.PrintMessages[0], "Throttling the fetches"))
+		assert.NoError(t, consumer.Shutdown(ctx))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		t.Parallel()
+
+		reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0})
+
+		opts := listener.NewOptionsListener().
+			WithAdaptiveThrottle(1).
+			WithThrottleDelay(time.Hour).
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		consumer := listener.NewListener(log.NewLogger(), opts)
+
+		_, err := consumer.ConsumeN(ctx, 1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// TestMaxMessageAge checks that the messages older than the maximum age, by the given clock,
+// are skipped and counted instead of delivered.
+func TestMaxMessageAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stale := kafka.Message{Offset: 0, Value: []byte("stale message"), Time: now.Add(-2 * time.Minute)}
+	untimed := kafka.Message{Offset: 1, Value: []byte("untimed message")}
+	fresh := kafka.Message{Offset: 2, Value: []byte("fresh message"), Time: now.Add(-time.Second)}
+	reader := kafkotest.NewFakeReader(stale, untimed, fresh)
+
+	staleMetric := &MockIncrementer{}
+	staleMetric.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithMaxMessageAge(time.Minute).
+		WithClock(func() time.Time {
+			return now
+		}).
+		WithMetricMessagesStale(staleMetric).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	messages, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kafka.Message{untimed, fresh}, messages)
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	staleMetric.AssertNumberOfCalls(t, "Inc", 1)
+}
+
+// TestCheckpointOnAssign checks that the position of every partition is committed on its first
+// fetch, before its messages are processed.
+func TestCheckpointOnAssign(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Partition: 0, Offset: 5},
+		kafka.Message{Partition: 0, Offset: 6},
+		kafka.Message{Partition: 1, Offset: 3},
+	)
+
+	opts := listener.NewOptionsListener().
+		WithCheckpointOnAssign().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 3)
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	assert.Equal(t, []kafka.Message{
+		{Partition: 0, Offset: 4},
+		{Partition: 1, Offset: 2},
+		{Partition: 0, Offset: 5},
+		{Partition: 0, Offset: 6},
+		{Partition: 1, Offset: 3},
+	}, reader.Committed())
+}
+
+// TestEnricher checks that the messages are enriched before their delivery, by Listen and ListenFunc,
+// and that the ones failing the enrichment are processed as dropped messages.
+func TestEnricher(t *testing.T) {
+	t.Parallel()
+
+	enricher := func(ctx context.Context, message *kafka.Message) error {
+		if string(message.Value) == "unknown" {
+			return errors.New("no reference value")
+		}
+
+		message.Value = append(message.Value, []byte(" enriched")...)
+
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		listen func(ctx context.Context, consumer *listener.Listener, received chan<- []byte) error
+	}{
+		{"Listen", func(ctx context.Context, consumer *listener.Listener, received chan<- []byte) error {
+			go func() {
+				// The shutdown closes the channels, which stops Consume.
+				assert.NoError(t, consumer.Consume(context.Background(), func(msg []byte) error {
+					received <- msg
+
+					return nil
+				}))
+			}()
+
+			return consumer.Listen(ctx)
+		}},
+		{"ListenFunc", func(ctx context.Context, consumer *listener.Listener, received chan<- []byte) error {
+			return consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+				received <- message.Value
+
+				return nil
+			})
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			unknown := kafka.Message{Offset: 0, Value: []byte("unknown")}
+			known := kafka.Message{Offset: 1, Value: []byte("known")}
+			reader := kafkotest.NewFakeReader(unknown, known)
+			dropped := make(chan kafka.Message, 1)
+			received := make(chan []byte, 1)
+
+			opts := listener.NewOptionsListener().
+				WithEnricher(enricher).
+				WithReaderFactory(func() listener.Reader {
+					return reader
+				}).
+				WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+					dropped <- *msg
+
+					return nil
+				})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			consumer := listener.NewListener(log.NewLogger(), opts)
+
+			go func() {
+				assert.Equal(t, []byte("known enriched"), <-received)
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+
+			assert.NoError(t, test.listen(ctx, consumer, received))
+			assert.Equal(t, unknown, <-dropped)
+		})
+	}
+}
+
+// TestTombstoneHandler checks that the tombstones are processed by the tombstone handler
+// instead of being skipped like the empty messages.
+func TestTombstoneHandler(t *testing.T) {
+	t.Parallel()
+
+	tombstone := kafka.Message{Offset: 0, Key: []byte("key")}
+	empty := kafka.Message{Offset: 1, Value: []byte{}}
+	msg := kafka.Message{Offset: 2, Value: []byte("test message")}
+	reader := kafkotest.NewFakeReader(tombstone, empty, msg)
+	tombstones := []kafka.Message{}
+
+	opts := listener.NewOptionsListener().
+		WithSkipEmptyMessages(true).
+		WithTombstoneHandler(func(ctx context.Context, message kafka.Message) error {
+			tombstones = append(tombstones, message)
+
+			return nil
+		}).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+		assert.Equal(t, msg.Value, <-msgChan)
+
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{tombstone}, tombstones)
+	assert.True(t, listener.IsTombstone(tombstone))
+	assert.False(t, listener.IsTombstone(empty))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 2}}, reader.Committed())
+}
+
+// TestRebalanceHooks checks that a rebalance commits the uncommitted messages before revoking
+// the partitions and then assigns the new ones, counting the rebalance.
+func TestRebalanceHooks(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Topic: "topic", Partition: 0, Offset: 0}
+	second := kafka.Message{Topic: "topic", Partition: 0, Offset: 1}
+	partitions := []listener.TopicPartition{{Topic: "topic", Partition: 0}, {Topic: "topic", Partition: 1}}
+	errorAtCommitMessages := kafka.NetworkException
+
+	reader := kafkotest.NewFakeReader(first, second).
+		FailCommit(&errorAtCommitMessages).
+		Rebalance(1, partitions...)
+
+	assigned := [][]listener.TopicPartition{}
+	revoked := [][]listener.TopicPartition{}
+	committedOnRevoke := []kafka.Message{}
+
+	rebalances := &MockIncrementer{}
+	rebalances.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithMetricRebalances(rebalances).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithOnPartitionsAssigned(func(partitions []listener.TopicPartition) {
+			assigned = append(assigned, partitions)
+		}).
+		WithOnPartitionsRevoked(func(partitions []listener.TopicPartition) {
+			revoked = append(revoked, partitions)
+			committedOnRevoke = reader.Committed()
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// The commit fails with a recoverable error, so the first message stays uncommitted.
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	reader.Rebalance(2, partitions[0])
+
+	_, err = consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]listener.TopicPartition{partitions, partitions[:1]}, assigned)
+	assert.Equal(t, [][]listener.TopicPartition{partitions}, revoked)
+	assert.Equal(t, []kafka.Message{first}, committedOnRevoke)
+	rebalances.AssertNumberOfCalls(t, "Inc", 1)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestGeneration checks that the generation is reported by Generation and handed to the
+// handler and to the committer, so they can fence the work of a previous generation.
+func TestGeneration(t *testing.T) {
+	t.Parallel()
+
+	partition := listener.TopicPartition{Topic: "topic", Partition: 0}
+	reader := kafkotest.NewFakeReader(kafka.Message{Topic: "topic", Offset: 0}).Rebalance(3, partition)
+
+	handled := make(chan int32, 1)
+	committed := make(chan int32, 1)
+
+	opts := listener.NewOptionsListener().
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			generationID, _ := listener.GenerationFromContext(ctx)
+			committed <- generationID
+
+			return nil
+		})).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, ok := consumer.Generation()
+	assert.False(t, ok)
+
+	go func() {
+		assert.Equal(t, int32(3), <-handled)
+		assert.Equal(t, int32(3), <-committed)
+
+		generationID, ok := consumer.Generation()
+		assert.True(t, ok)
+		assert.Equal(t, int32(3), generationID)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		generationID, ok := listener.GenerationFromContext(ctx)
+		assert.True(t, ok)
+		handled <- generationID
+
+		return nil
+	}))
+}
+
+// TestReleaseUnownedAfterReconnect checks that, after a reconnection, the uncommitted messages of
+// the partitions no longer assigned are discarded instead of committed, without being dropped.
+func TestReleaseUnownedAfterReconnect(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Topic: "topic", Partition: 0, Offset: 0}
+	second := kafka.Message{Topic: "topic", Partition: 1, Offset: 0}
+	partitions := []listener.TopicPartition{{Topic: "topic", Partition: 0}, {Topic: "topic", Partition: 1}}
+	errorAtCommitMessages := kafka.NetworkException
+
+	reader := kafkotest.NewFakeReader(first, second).
+		FailCommit(&errorAtCommitMessages).
+		Rebalance(1, partitions...)
+
+	dropped := []kafka.Message{}
+	released := new(MockIncrementer)
+	released.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithMetricMessagesReleased(released).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped = append(dropped, *msg)
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// The commit fails with a recoverable error, so the reader is replaced and the first message stays uncommitted.
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	// The new generation doesn't assign the partition of the first message anymore.
+	reader.Rebalance(2, partitions[1])
+
+	_, err = consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	assert.Empty(t, dropped)
+	released.AssertNumberOfCalls(t, "Inc", 1)
+	assert.Equal(t, []kafka.Message{second}, reader.Committed())
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestShutdownTimeout checks that a wedged commit doesn't hang the shutdown forever.
+func TestShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+	unblock := make(chan struct{})
+	committing := make(chan struct{})
+
+	opts := listener.NewOptionsListener().
+		WithShutdownTimeout(100 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			close(committing)
+			<-unblock
+
+			return nil
+		}))
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		_, err := consumer.ConsumeN(context.Background(), 1)
+		assert.NoError(t, err)
+	}()
+
+	<-committing
+
+	start := time.Now()
+
+	assert.ErrorIs(t, consumer.Shutdown(context.Background()), listener.ErrShutdownTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, reader.Closed())
+
+	close(unblock)
+}
+
+// TestShutdownClosesBlockedFetch checks that the io.EOF a blocked fetch returns once Shutdown closes
+// the reader under it ends Listen cleanly instead of as an unrecoverable error.
+func TestShutdownClosesBlockedFetch(t *testing.T) {
+	t.Parallel()
+
+	closed := make(chan struct{})
+
+	mockReader := new(MockKafkaReader)
+	mockReader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { <-closed }).Return(kafka.Message{}, io.EOF)
+	mockReader.On("Close").Run(func(mock.Arguments) { close(closed) }).Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithShutdownTimeout(100 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return mockReader
+		})
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	stopped := consumer.RunAsync(context.Background())
+
+	// Let the fetch block before shutting down.
+	time.Sleep(50 * time.Millisecond)
+
+	assert.ErrorIs(t, consumer.Shutdown(context.Background()), listener.ErrShutdownTimeout)
+	assert.NoError(t, <-stopped)
+}
+
+// TestCommitAfterClose checks that nothing is committed through the reader once it was closed.
+func TestCommitAfterClose(t *testing.T) {
+	t.Parallel()
+
+	errorAtCommitMessages := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}).
+		FailCommit(&errorAtCommitMessages, &errorAtCommitMessages)
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	// The message is left uncommitted by both ConsumeN and Shutdown.
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+	assert.Error(t, consumer.Shutdown(ctx))
+	assert.True(t, reader.Closed())
+
+	assert.ErrorIs(t, consumer.Flush(ctx), listener.ErrReaderClosed)
+	assert.Empty(t, reader.Committed())
+}
+
+// TestDeliveryContext checks that the context of a delivery is canceled once its processing times out.
+func TestDeliveryContext(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0, Value: []byte("slow")}, kafka.Message{Offset: 1, Value: []byte("fast")})
+	dropped := make(chan int64, 1)
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Offset
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		// The slow message is aborted once its processing timed out.
+		<-msgChan
+		<-consumer.DeliveryContext().Done()
+		assert.Equal(t, int64(0), <-dropped)
+
+		<-msgChan
+		assert.NoError(t, consumer.DeliveryContext().Err())
+		errChan <- nil
+
+		assert.Eventually(t, func() bool {
+			return len(reader.Committed()) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestAckLatency checks that the time from the delivery to the acknowledgement is measured.
+func TestAckLatency(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+
+	latency := new(MockDuration)
+	latency.On("Observe", mock.MatchedBy(func(milliseconds float64) bool {
+		return milliseconds >= 50
+	})).Return()
+
+	opts := listener.NewOptionsListener().
+		WithMetricAckLatency(latency).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		<-msgChan
+		time.Sleep(50 * time.Millisecond)
+		errChan <- nil
+
+		assert.Eventually(t, func() bool {
+			return len(reader.Committed()) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	latency.AssertNumberOfCalls(t, "Observe", 1)
+}
+
+// TestFetchAndCommitLatency checks that the time spent fetching and committing is observed separately.
+func TestFetchAndCommitLatency(t *testing.T) {
+	t.Parallel()
+
+	reader := &slowReader{FakeReader: kafkotest.NewFakeReader(kafka.Message{Offset: 0}), delay: 50 * time.Millisecond}
+
+	fetchLatency := new(MockDuration)
+	fetchLatency.On("Observe", mock.MatchedBy(func(milliseconds float64) bool {
+		return milliseconds >= 50
+	})).Return()
+
+	commitLatency := new(MockDuration)
+	commitLatency.On("Observe", mock.MatchedBy(func(milliseconds float64) bool {
+		return milliseconds >= 20 && milliseconds < 50
+	})).Return()
+
+	opts := listener.NewOptionsListener().
+		WithMetricFetchLatency(fetchLatency).
+		WithMetricCommitLatency(commitLatency).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			time.Sleep(20 * time.Millisecond)
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	fetchLatency.AssertNumberOfCalls(t, "Observe", 1)
+	commitLatency.AssertNumberOfCalls(t, "Observe", 1)
+}
+
+// TestDropSemantics checks that a message dropped by timeout is committed only if the dropped
+// message handler owns it, and is counted as rescued only if the handler processed it.
+func TestDropSemantics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		semantics     listener.DropSemantics
+		handlerErr    error
+		expectDropped int
+		expectCommits int
+		expectLost    int
+		expectRescued int
+	}{
+		{"Terminal", listener.DropTerminal, nil, 1, 1, 0, 1},
+		{"TerminalHandlerFails", listener.DropTerminal, errors.New("dlq unavailable"), 1, 0, 1, 0},
+		{"Redeliver", listener.DropRedeliver, nil, 0, 0, 1, 0},
+		{"Informational", listener.DropInformational, nil, 1, 0, 0, 1},
+		{"Default", 0, nil, 1, 1, 0, 1},
+		{"DefaultHandlerFails", 0, errors.New("dlq unavailable"), 1, 0, 1, 0},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+			dropped := make(chan struct{}, 1)
+
+			lost := &MockIncrementer{}
+			lost.On("Inc").Return()
+
+			rescued := &MockIncrementer{}
+			rescued.On("Inc").Return()
+
+			opts := listener.NewOptionsListener().
+				WithProcessingTimeout(50 * time.Millisecond).
+				WithDropSemantics(test.semantics).
+				WithMetricMessagesDropped(lost).
+				WithMetricMessagesRescued(rescued).
+				WithReaderFactory(func() listener.Reader {
+					return reader
+				}).
+				WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+					dropped <- struct{}{}
+
+					return test.handlerErr
+				})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			consumer := listener.NewListener(log.NewLogger(), opts)
+
+			go func() {
+				// Nobody reads the message, so it's dropped once the processing times out.
+				time.Sleep(200 * time.Millisecond)
+
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+
+			assert.NoError(t, consumer.Listen(ctx))
+			assert.Len(t, dropped, test.expectDropped)
+			assert.Len(t, reader.Committed(), test.expectCommits)
+			lost.AssertNumberOfCalls(t, "Inc", test.expectLost)
+			rescued.AssertNumberOfCalls(t, "Inc", test.expectRescued)
+		})
+	}
+}
+
+type tenantKey struct{}
+
+// TestDroppedMsgContext checks that the dropped message handler receives the context given to Listen.
+func TestDroppedMsgContext(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+	tenants := make(chan any, 1)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			tenants <- ctx.Value(tenantKey{})
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), tenantKey{}, "tenant"), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, ackChan := consumer.MessageAndAckChannels()
+
+		<-msgChan
+		ackChan <- listener.Drop
+
+		assert.Equal(t, "tenant", <-tenants)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+}
+
+// TestReconnectBackoff checks that the reconnections back off from the initial backoff up to the maximum one.
+func TestReconnectBackoff(t *testing.T) {
+	t.Parallel()
+
+	errorAtFetchMessage := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")}).
+		FailFetch(&errorAtFetchMessage, &errorAtFetchMessage, &errorAtFetchMessage, &errorAtFetchMessage)
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(time.Hour).
+		WithInitialBackoff(10 * time.Millisecond).
+		WithMaxBackoff(40 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	start := time.Now()
+
+	_, err := consumer.ConsumeN(ctx, 1)
+	assert.NoError(t, err)
+
+	// The reconnections wait 10ms, 20ms, 40ms and 40ms.
+	assert.GreaterOrEqual(t, time.Since(start), 110*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// idleFailingReader fails every other fetch with a recoverable error, up to failures times, and leaves
+// the batch window elapse empty in between, like a broker timing out. Then it fetches message.
+type idleFailingReader struct {
+	*kafkotest.FakeReader
+
+	message  kafka.Message
+	failures int32
+	fetches  int32
+}
+
+func (reader *idleFailingReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	fetch := atomic.AddInt32(&reader.fetches, 1)
+
+	if fetch%2 == 1 && fetch/2 < reader.failures {
+		if fetch/2 == reader.failures-1 {
+			reader.Push(reader.message)
+		}
+
+		errorAtFetchMessage := kafka.NetworkException
+
+		return kafka.Message{}, &errorAtFetchMessage
+	}
+
+	return reader.FakeReader.FetchMessage(ctx)
+}
+
+// TestReconnectBackoffBatchIdle checks that in batch mode the batch windows elapsing empty between the
+// failures don't reset the backoff of the reconnections, as no message was fetched.
+func TestReconnectBackoffBatchIdle(t *testing.T) {
+	t.Parallel()
+
+	message := kafka.Message{Value: []byte("test message")}
+	reader := &idleFailingReader{FakeReader: kafkotest.NewFakeReader(), message: message, failures: 4}
+
+	opts := listener.NewOptionsListener().
+		WithBatchDelivery(1, 5*time.Millisecond).
+		WithReconnectInterval(time.Hour).
+		WithInitialBackoff(10 * time.Millisecond).
+		WithMaxBackoff(40 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	start := time.Now()
+
+	go func() {
+		batchChan, errChan := consumer.BatchAndErrorChannels()
+
+		assert.Equal(t, []kafka.Message{message}, <-batchChan)
+		errChan <- nil
+
+		// The reconnections wait 10ms, 20ms, 40ms and 40ms.
+		assert.GreaterOrEqual(t, time.Since(start), 110*time.Millisecond)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+}
+
+// TestMessagesFetchedMetric checks that every fetched message is counted, even the skipped ones.
+func TestMessagesFetchedMetric(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Value: []byte("first")},
+		kafka.Message{},
+		kafka.Message{Value: []byte("second")},
+	)
+
+	fetched := new(MockIncrementer)
+	fetched.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithSkipEmptyMessages(true).
+		WithMetricMessagesFetched(fetched).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	messages, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	fetched.AssertNumberOfCalls(t, "Inc", 3)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestMaxMessageSize checks that the oversized messages are handed to the dropped message handler
+// and committed instead of being delivered.
+func TestMaxMessageSize(t *testing.T) {
+	t.Parallel()
+
+	big := kafka.Message{Offset: 0, Value: []byte("oversized message")}
+	small := kafka.Message{Offset: 1, Value: []byte("small")}
+	reader := kafkotest.NewFakeReader(big, small)
+	dropped := make(chan int64, 1)
+
+	oversized := new(MockIncrementer)
+	oversized.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithMaxMessageSize(len(small.Value)).
+		WithMetricMessagesOversized(oversized).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Offset
+
+			// The oversized message is committed even if the dropped message handler fails.
+			return errors.New("failed to process the dropped message")
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+		assert.Equal(t, small.Value, <-msgChan)
+
+		errChan <- nil
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, int64(0), <-dropped)
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+	oversized.AssertNumberOfCalls(t, "Inc", 1)
+}
+
+// TestRunAsync checks that RunAsync yields the error of Listen once it stops.
+func TestRunAsync(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	stopped := consumer.RunAsync(ctx)
+
+	msgChan, errChan := consumer.MessageAndErrorChannels()
+	assert.Equal(t, []byte("test message"), <-msgChan)
+
+	errChan <- nil
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+	assert.NoError(t, <-stopped)
+
+	_, open := <-stopped
+	assert.False(t, open)
+
+	// A second run fails right away as the listener was shut down.
+	assert.ErrorIs(t, <-consumer.RunAsync(ctx), listener.ErrShuttingDown)
+}
+
+// TestConsume checks that Consume acknowledges every message with the result of the handler and
+// returns once the shutdown closes the channels.
+func TestConsume(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Offset: 0, Value: []byte("first message")}
+	second := kafka.Message{Offset: 1, Value: []byte("second message")}
+	reader := kafkotest.NewFakeReader(first, second)
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	stopped := consumer.RunAsync(ctx)
+	received := [][]byte{}
+
+	err := consumer.Consume(ctx, func(msg []byte) error {
+		received = append(received, msg)
+
+		if len(received) == 1 {
+			return nil
+		}
+
+		go func() {
+			assert.NoError(t, consumer.Shutdown(ctx))
+		}()
+
+		return errors.New("failed to process message")
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, [][]byte{first.Value, second.Value}, received)
+	// The failed message isn't committed.
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+}
+
+// TestConsumeSlowHandler checks that Consume doesn't send the result of a handler that outlived the processing
+// timeout on the error channel closed by the shutdown meanwhile.
+func TestConsumeSlowHandler(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0, Value: []byte("slow message")})
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(50 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	stopped := consumer.RunAsync(ctx)
+
+	err := consumer.Consume(ctx, func(msg []byte) error {
+		// The message times out, so the shutdown closes the channels while the handler runs.
+		assert.NoError(t, consumer.Shutdown(ctx))
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, <-stopped)
+	assert.Empty(t, reader.Committed())
+}
+
+// slowReader is a FakeReader whose fetches are slow, like over a degraded connection.
+type slowReader struct {
+	*kafkotest.FakeReader
+
+	delay time.Duration
+}
+
+func (reader *slowReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	time.Sleep(reader.delay)
+
+	return reader.FakeReader.FetchMessage(ctx) //nolint:wrapcheck
+}
+
+// TestReaderHealthProbe checks that the reader is swapped after consecutive slow fetches.
+func TestReaderHealthProbe(t *testing.T) {
+	t.Parallel()
+
+	slow := &slowReader{
+		FakeReader: kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1}, kafka.Message{Offset: 2}),
+		delay:      50 * time.Millisecond,
+	}
+	fast := kafkotest.NewFakeReader(kafka.Message{Offset: 3})
+	readers := []listener.Reader{slow, fast}
+
+	swaps := new(MockIncrementer)
+	swaps.On("Inc").Return()
+
+	opts := listener.NewOptionsListener().
+		WithReaderHealthProbe(20 * time.Millisecond).
+		WithMetricHealthProbeSwaps(swaps).
+		WithReaderFactory(func() listener.Reader {
+			reader := readers[0]
+			readers = readers[1:]
+
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	done := make(chan struct{})
+
+	go func() {
+		<-done
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		if message.Offset == 3 {
+			close(done)
+		}
+
+		return nil
+	}))
+
+	swaps.AssertNumberOfCalls(t, "Inc", 1)
+	assert.True(t, slow.Closed())
+}
+
+// headerValue returns the value of the header of the message with the given key, empty if it has none.
+func headerValue(message kafka.Message, key string) string {
+	for _, header := range message.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+// TestRetryTopics checks that the nacked messages are republished to the next retry topic until they
+// went through every one of them, and that the retried messages are held until they are due.
+func TestRetryTopics(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	notBefore := time.UnixMilli(time.Now().Add(100 * time.Millisecond).UnixMilli())
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Offset: 0, Value: []byte("first")},
+		kafka.Message{Offset: 1, Value: []byte("second"), Headers: []kafka.Header{
+			{Key: listener.RetryHeaderAttempts, Value: []byte("1")},
+			{Key: listener.RetryHeaderNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+		}},
+		kafka.Message{Offset: 2, Value: []byte("third"), Headers: []kafka.Header{
+			{Key: listener.RetryHeaderAttempts, Value: []byte("2")},
+		}},
+	)
+
+	retried := make(chan kafka.Message, 2)
+	dropped := make(chan int64, 1)
+
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		msgs, _ := args.Get(1).([]kafka.Message)
+		retried <- msgs[0]
+	}).Return(nil)
+
+	opts := listener.NewOptionsListener().
+		WithRetryTopics(map[time.Duration]string{time.Minute: "retry-long", time.Second: "retry-short"}, writer).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Offset
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		for i := 0; i < 3; i++ {
+			value := <-msgChan
+			if i == 1 {
+				assert.False(t, time.Now().Before(notBefore), "the retried message was delivered before it was due")
+			}
+
+			errChan <- errors.New("failed to process " + string(value))
+		}
+
+		assert.Equal(t, int64(2), <-dropped)
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+
+	first := <-retried
+	assert.Equal(t, "retry-short", first.Topic)
+	assert.Equal(t, []byte("first"), first.Value)
+	assert.Equal(t, "1", headerValue(first, listener.RetryHeaderAttempts))
+
+	second := <-retried
+	assert.Equal(t, "retry-long", second.Topic)
+	assert.Equal(t, "2", headerValue(second, listener.RetryHeaderAttempts))
+
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+}
+
+// TestRetryTopicsHandlerErrors checks that the errors of the handlers of ListenFunc, even with
+// WithOrderedConcurrency, and ProcessOnce and the ones given to the acknowledgement of Next are retried like a Nack.
+func TestRetryTopicsHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	errorAtHandler := errors.New("failed to process message")
+
+	tests := []struct {
+		name    string
+		opts    *listener.OptionsListener
+		consume func(ctx context.Context, consumer *listener.Listener) error
+	}{
+		{"ListenFunc", listener.NewOptionsListener(), func(ctx context.Context, consumer *listener.Listener) error {
+			return consumer.ListenFunc(ctx, func(context.Context, kafka.Message) error {
+				go func() {
+					assert.NoError(t, consumer.Shutdown(ctx))
+				}()
+
+				return errorAtHandler
+			})
+		}},
+		{"OrderedConcurrency", listener.NewOptionsListener().WithOrderedConcurrency(2), func(ctx context.Context, consumer *listener.Listener) error {
+			return consumer.ListenFunc(ctx, func(context.Context, kafka.Message) error {
+				go func() {
+					assert.NoError(t, consumer.Shutdown(ctx))
+				}()
+
+				return errorAtHandler
+			})
+		}},
+		{"ProcessOnce", listener.NewOptionsListener(), func(ctx context.Context, consumer *listener.Listener) error {
+			if err := consumer.ProcessOnce(ctx, func(context.Context, kafka.Message) error {
+				return errorAtHandler
+			}); err != nil {
+				return err
+			}
+
+			return consumer.Shutdown(ctx)
+		}},
+		{"Next", listener.NewOptionsListener(), func(ctx context.Context, consumer *listener.Listener) error {
+			_, ack, err := consumer.Next(ctx)
+			if err != nil {
+				return err
+			}
+
+			ack(errorAtHandler)
+
+			return consumer.Shutdown(ctx)
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0, Value: []byte("first")})
+			retried := make(chan kafka.Message, 1)
+			dropped := make(chan int64, 1)
+
+			writer := new(MockWriter)
+			writer.On("WriteMessages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				msgs, _ := args.Get(1).([]kafka.Message)
+				retried <- msgs[0]
+			}).Return(nil)
+
+			opts := test.opts.
+				WithRetryTopics(map[time.Duration]string{time.Second: "retry"}, writer).
+				WithReaderFactory(func() listener.Reader {
+					return reader
+				}).
+				WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+					dropped <- msg.Offset
+
+					return nil
+				})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			assert.NoError(t, test.consume(ctx, listener.NewListener(log.NewLogger(), opts)))
+
+			select {
+			case retry := <-retried:
+				assert.Equal(t, "retry", retry.Topic)
+				assert.Equal(t, "1", headerValue(retry, listener.RetryHeaderAttempts))
+			default:
+				assert.Fail(t, "the message wasn't retried")
+			}
+
+			assert.Empty(t, dropped)
+			assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+		})
+	}
+}
+
+// TestListenAfterShutdown checks that Listen refuses to start after Shutdown or with a done context.
+func TestListenAfterShutdown(t *testing.T) {
+	t.Parallel()
+
+	newConsumer := func() *listener.Listener {
+		reader := kafkotest.NewFakeReader()
+		opts := listener.NewOptionsListener().
+			WithReaderFactory(func() listener.Reader {
+				return reader
+			})
+
+		return listener.NewListener(log.NewLogger(), opts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := newConsumer()
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+	assert.ErrorIs(t, consumer.Listen(ctx), listener.ErrShuttingDown)
+
+	canceledCtx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	consumer = newConsumer()
+
+	assert.ErrorIs(t, consumer.Listen(canceledCtx), context.Canceled)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestNext checks that Next returns the messages one by one and commits only the acknowledged ones.
+func TestNext(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+	droppedMessages := 0
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			droppedMessages++
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	message, ack, err := consumer.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), message.Offset)
+
+	ack(nil)
+	ack(errors.New("acknowledged twice")) //nolint:goerr113
+
+	message, ack, err = consumer.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), message.Offset)
+
+	ack(errors.New("poison message")) //nolint:goerr113
+
+	assert.Equal(t, []kafka.Message{{Offset: 0}}, reader.Committed())
+	assert.Equal(t, 1, droppedMessages)
+	assert.NoError(t, consumer.Shutdown(ctx))
+
+	_, _, err = consumer.Next(ctx)
+	assert.ErrorIs(t, err, listener.ErrShuttingDown)
+}
+
+// fetchSignalingReader signals every call to FetchMessage before fetching.
+type fetchSignalingReader struct {
+	*kafkotest.FakeReader
+
+	fetching chan struct{}
+}
+
+func (reader *fetchSignalingReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	reader.fetching <- struct{}{}
+
+	return reader.FakeReader.FetchMessage(ctx)
+}
+
+// TestNextWhileListening checks that the Listener can't listen while Next runs, while several
+// calls to Next can run at once.
+func TestNextWhileListening(t *testing.T) {
+	t.Parallel()
+
+	reader := &fetchSignalingReader{FakeReader: kafkotest.NewFakeReader(), fetching: make(chan struct{}, 10)}
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	offsets := make(chan int64, 2)
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			message, ack, err := consumer.Next(ctx)
+			assert.NoError(t, err)
+
+			ack(nil)
+
+			offsets <- message.Offset
+		}()
+	}
+
+	// A call to Next waits for a message.
+	<-reader.fetching
+
+	assert.ErrorIs(t, consumer.Listen(ctx), listener.ErrAlreadyListening)
+	assert.ErrorIs(t, consumer.ProcessOnce(ctx, func(ctx context.Context, message kafka.Message) error {
+		return nil
+	}), listener.ErrAlreadyListening)
+
+	reader.Push(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+
+	assert.ElementsMatch(t, []int64{0, 1}, []int64{<-offsets, <-offsets})
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestTopicProcessingTimeout checks that the processing timeout of the topic of a message overrides the global one.
+func TestTopicProcessingTimeout(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(
+		kafka.Message{Topic: "fast", Value: []byte("fast message")},
+		kafka.Message{Topic: "slow", Value: []byte("slow message")},
+	)
+	dropped := make(chan string, 2)
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(100 * time.Millisecond).
+		WithTopicProcessingTimeout(map[string]time.Duration{"slow": time.Second}).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Topic
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		// The fast message is dropped, the slow one is acknowledged within its own timeout.
+		<-msgChan
+		assert.Equal(t, "fast", <-dropped)
+
+		<-msgChan
+		time.Sleep(300 * time.Millisecond)
+		errChan <- nil
+
+		assert.Eventually(t, func() bool {
+			return len(reader.Committed()) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Topic: "fast"}, {Topic: "slow"}}, reader.Committed())
+	assert.Empty(t, dropped)
+}
+
+// TestDeliveryAndAckTimeouts checks that a consumer not receiving a message is detected by the delivery
+// timeout while a slow acknowledgement is tolerated up to the ack timeout.
+func TestDeliveryAndAckTimeouts(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1})
+	dropped := make(chan int64, 2)
+
+	opts := listener.NewOptionsListener().
+		WithDeliveryTimeout(100 * time.Millisecond).
+		WithAckTimeout(time.Second).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- msg.Offset
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, errChan := consumer.MessageAndErrorChannels()
+
+		// The first message isn't received in time, the second one is acknowledged slowly.
+		assert.Equal(t, int64(0), <-dropped)
+
+		<-msgChan
+		time.Sleep(300 * time.Millisecond)
+		errChan <- nil
+
+		assert.Eventually(t, func() bool {
+			return len(reader.Committed()) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}}, reader.Committed())
+	assert.Empty(t, dropped)
+}
+
+// TestIsRedelivery checks that a message fetched again without being committed is flagged as a redelivery.
+func TestIsRedelivery(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1}, kafka.Message{Offset: 1})
+
+	opts := listener.NewOptionsListener().
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	redeliveries := []bool{}
+
+	err := consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		redeliveries = append(redeliveries, listener.IsRedelivery(ctx))
+
+		if len(redeliveries) == 3 {
+			go func() {
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+		}
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false, true}, redeliveries)
+}
+
+// TestCommitRetriesByPartition checks that a failed commit is retried partition by partition,
+// so only the partitions that couldn't be committed are kept as uncommitted.
+func TestCommitRetriesByPartition(t *testing.T) {
+	t.Parallel()
+
+	first := kafka.Message{Topic: "topic", Partition: 0, Offset: 10}
+	second := kafka.Message{Topic: "topic", Partition: 1, Offset: 20}
+	reader := kafkotest.NewFakeReader(first, second)
+	errorAtCommitMessages := kafka.NetworkException
+
+	var secondAvailable int32
+
+	mutex := &sync.Mutex{}
+	commits := [][]kafka.Message{}
+
+	opts := listener.NewOptionsListener().
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithCommitter(listener.CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+			// The commit of both partitions at once fails, as does the second partition until it's available.
+			if len(msgs) > 1 || (msgs[0].Partition == second.Partition && atomic.LoadInt32(&secondAvailable) == 0) {
+				return &errorAtCommitMessages
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			commits = append(commits, msgs)
+
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	_, err := consumer.ConsumeN(ctx, 2)
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(&secondAvailable, 1)
+
+	assert.NoError(t, consumer.Flush(ctx))
+	assert.Equal(t, [][]kafka.Message{{{Topic: "topic", Partition: 0, Offset: 10}}, {{Topic: "topic", Partition: 1, Offset: 20}}}, commits)
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
+
+// TestPrefetch checks that the prefetched messages are processed and committed in fetch order,
+// even after a recoverable fetch error.
+func TestPrefetch(t *testing.T) {
+	t.Parallel()
+
+	errorAtFetchMessage := kafka.NetworkException
+	reader := kafkotest.NewFakeReader(kafka.Message{Offset: 0}, kafka.Message{Offset: 1}, kafka.Message{Offset: 2}).
+		FailFetch(&errorAtFetchMessage)
+
+	opts := listener.NewOptionsListener().
+		WithPrefetch(2).
+		WithReconnectInterval(10 * time.Millisecond).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+	offsets := []int64{}
+
+	err := consumer.ListenFunc(ctx, func(ctx context.Context, message kafka.Message) error {
+		offsets = append(offsets, message.Offset)
+
+		if len(offsets) == 3 {
+			go func() {
+				assert.NoError(t, consumer.Shutdown(ctx))
+			}()
+		}
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2}, offsets)
+
+	assert.Eventually(t, reader.Closed, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []kafka.Message{{Offset: 0}, {Offset: 1}, {Offset: 2}}, reader.Committed())
+}
+
+// TestSetProcessingTimeout checks that the processing timeout can be replaced at runtime.
+func TestSetProcessingTimeout(t *testing.T) {
+	t.Parallel()
+
+	reader := kafkotest.NewFakeReader(kafka.Message{Value: []byte("test message")})
+	dropped := make(chan struct{}, 1)
+
+	opts := listener.NewOptionsListener().
+		WithProcessingTimeout(time.Hour).
+		WithReaderFactory(func() listener.Reader {
+			return reader
+		}).
+		WithProcessDroppedMsg(func(ctx context.Context, msg *kafka.Message, log listener.Logger) error {
+			dropped <- struct{}{}
+
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := listener.NewListener(log.NewLogger(), opts)
+
+	assert.ErrorIs(t, consumer.SetProcessingTimeout(0), listener.ErrInvalidTimeout)
+	assert.NoError(t, consumer.SetProcessingTimeout(50*time.Millisecond))
+
+	go func() {
+		// Nobody reads the message, so it's dropped once the new processing timeout elapses.
+		<-dropped
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+}
@@ -14,11 +14,16 @@ type Logger interface {
 }
 
 type MockLogger struct {
+	DebugMessages []string
 	PrintMessages []string
 	PanicMessages []string
 	ErrorMessages []string
 }
 
+func (m *MockLogger) Debugf(format string, v ...interface{}) {
+	m.DebugMessages = append(m.DebugMessages, fmt.Sprintf(format, v...))
+}
+
 func (m *MockLogger) Printf(format string, v ...interface{}) {
 	m.PrintMessages = append(m.PrintMessages, fmt.Sprintf(format, v...))
 }
@@ -38,9 +43,24 @@ func NewMockLogger() *MockLogger {
 type LoggerInternal struct {
 	err,
 	info,
+	debug,
 	panic zerolog.Logger
 }
 
+// WithLevel discards the log lines below the given level, e.g. zerolog.InfoLevel quiets Debugf.
+func (log *LoggerInternal) WithLevel(level zerolog.Level) *LoggerInternal {
+	log.err = log.err.Level(level)
+	log.info = log.info.Level(level)
+	log.debug = log.debug.Level(level)
+	log.panic = log.panic.Level(level)
+
+	return log
+}
+
+func (log *LoggerInternal) Debugf(format string, v ...any) {
+	log.debug.Debug().Msgf(format, v...)
+}
+
 func (log *LoggerInternal) Panicf(err error, format string, v ...any) {
 	log.panic.Panic().Err(errors.WithStack(err)).Msgf(format, v...)
 }
@@ -61,6 +81,7 @@ func newConsoleLogger() *LoggerInternal {
 	consoleWriter := zerolog.NewConsoleWriter()
 	devLogger := &LoggerInternal{
 		info:  zerolog.New(consoleWriter),
+		debug: zerolog.New(consoleWriter),
 		err:   zerolog.New(consoleWriter),
 		panic: zerolog.New(consoleWriter),
 	}
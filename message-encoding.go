@@ -0,0 +1,95 @@
+package kafko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// encodedMessage is the JSON form of a kafka.Message, see EncodeMessage.
+type encodedMessage struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Key       []byte          `json:"key"`
+	Value     []byte          `json:"value"`
+	Headers   []encodedHeader `json:"headers"`
+}
+
+type encodedHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// EncodeMessage encodes the topic, partition, offset, key, value and headers of the message,
+// so it can be persisted and replayed later, see DecodeMessage.
+func EncodeMessage(message kafka.Message) ([]byte, error) {
+	encoded := encodedMessage{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   make([]encodedHeader, 0, len(message.Headers)),
+	}
+
+	for _, header := range message.Headers {
+		encoded.Headers = append(encoded.Headers, encodedHeader{Key: header.Key, Value: header.Value})
+	}
+
+	bytes, err := json.Marshal(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "bytes, err := json.Marshal(encoded)")
+	}
+
+	return bytes, nil
+}
+
+// DecodeMessage decodes a message encoded by EncodeMessage.
+func DecodeMessage(bytes []byte) (kafka.Message, error) {
+	var encoded encodedMessage
+
+	if err := json.Unmarshal(bytes, &encoded); err != nil {
+		return kafka.Message{}, errors.Wrap(err, "err := json.Unmarshal(bytes, &encoded)")
+	}
+
+	message := kafka.Message{
+		Topic:     encoded.Topic,
+		Partition: encoded.Partition,
+		Offset:    encoded.Offset,
+		Key:       encoded.Key,
+		Value:     encoded.Value,
+	}
+
+	for _, header := range encoded.Headers {
+		message.Headers = append(message.Headers, kafka.Header{Key: header.Key, Value: header.Value})
+	}
+
+	return message, nil
+}
+
+// dropToFile returns a dropped message handler that writes every message, encoded by EncodeMessage,
+// to its own file in dir, named after its topic, partition and offset.
+func dropToFile(dir string) ProcessDroppedMsgHandler {
+	return func(_ context.Context, msg *kafka.Message, log Logger) error {
+		bytes, err := EncodeMessage(*msg)
+		if err != nil {
+			return errors.Wrap(err, "bytes, err := EncodeMessage(*msg)")
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d-%d.json", msg.Topic, msg.Partition, msg.Offset))
+
+		if err := os.WriteFile(path, bytes, 0o600); err != nil {
+			return errors.Wrapf(err, "err := os.WriteFile(path, bytes, 0o600) (path = %s)", path)
+		}
+
+		log.Printf("Dropped message written to %s", path)
+
+		return nil
+	}
+}
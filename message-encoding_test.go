@@ -0,0 +1,77 @@
+package kafko_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/kafkotest"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodeMessage checks that a message round-trips through EncodeMessage and DecodeMessage.
+func TestEncodeMessage(t *testing.T) {
+	t.Parallel()
+
+	message := kafka.Message{
+		Topic:     "topic",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   []kafka.Header{{Key: "x-tenant", Value: []byte("tenant")}},
+	}
+
+	bytes, err := kafko.EncodeMessage(message)
+	assert.NoError(t, err)
+
+	decoded, err := kafko.DecodeMessage(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, message, decoded)
+
+	_, err = kafko.DecodeMessage([]byte("not json"))
+	assert.Error(t, err)
+}
+
+// TestDropToFile checks that the dropped messages are written to the given directory.
+func TestDropToFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	message := kafka.Message{Topic: "topic", Partition: 3, Offset: 42, Value: []byte("test message")}
+	reader := kafkotest.NewFakeReader(message)
+
+	opts := kafko.NewOptionsListener().
+		WithDropToFile(dir).
+		WithReaderFactory(func() kafko.Reader {
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafko.NewListener(log.NewLogger(), opts)
+
+	go func() {
+		msgChan, ackChan := consumer.MessageAndAckChannels()
+
+		<-msgChan
+		ackChan <- kafko.Drop
+
+		assert.NoError(t, consumer.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, consumer.Listen(ctx))
+
+	bytes, err := os.ReadFile(filepath.Join(dir, "topic-3-42.json"))
+	assert.NoError(t, err)
+
+	decoded, err := kafko.DecodeMessage(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, message, decoded)
+}
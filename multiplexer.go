@@ -0,0 +1,207 @@
+package kafko
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// muxDelivery is a message fetched by a source of the Multiplexer, or the error that stopped it.
+type muxDelivery struct {
+	source  *muxSource
+	message kafka.Message
+	ack     func(error)
+	err     error
+}
+
+// muxSource is a Listener of the Multiplexer along with its scheduling state.
+type muxSource struct {
+	listener *Listener
+	weight   int
+	current  int           // Current weight of the smooth weighted round-robin, see pick.
+	head     *muxDelivery  // Message fetched and waiting for its turn, owned by the processing loop.
+	resume   chan struct{} // Lets the source fetch its next message once its head was picked.
+}
+
+// Multiplexer processes the messages of several Listeners, e.g. of topics with different priorities,
+// in a single processing loop. When several Listeners have a message ready, the next one to be processed
+// is picked by weighted round-robin, see WithWeight, so a busy Listener can't starve the others: with
+// weights 3 and 1, the first Listener gets 3 out of 4 turns while both have a backlog. Every Listener
+// has at most one message waiting for its turn.
+type Multiplexer struct {
+	sources []*muxSource
+
+	// cancel stops the fetches of Run, see Shutdown.
+	cancel      context.CancelFunc
+	cancelMutex sync.Mutex
+}
+
+// NewMultiplexer creates a Multiplexer of the given Listeners. They must not be used otherwise,
+// e.g. with Listen, as the Multiplexer fetches their messages with Next.
+func NewMultiplexer(listeners ...*Listener) *Multiplexer {
+	sources := make([]*muxSource, 0, len(listeners))
+
+	for _, listener := range listeners {
+		sources = append(sources, &muxSource{
+			listener: listener,
+			weight:   listener.opts.weight,
+			resume:   make(chan struct{}, 1),
+		})
+	}
+
+	return &Multiplexer{sources: sources}
+}
+
+// Run fetches the messages of every Listener and calls the handler with them, one at a time, until
+// ctx is done or Shutdown is called. Like ListenFunc, a message is committed if the handler returns
+// nil, otherwise it's processed as a dropped message. Run returns once the messages fetched so far
+// are handled, nil if it stopped cleanly, otherwise the first error that stopped a Listener.
+func (mux *Multiplexer) Run(ctx context.Context, handler Handler) error {
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mux.cancelMutex.Lock()
+	mux.cancel = cancel
+	mux.cancelMutex.Unlock()
+
+	arrivals := make(chan muxDelivery, len(mux.sources))
+
+	for _, source := range mux.sources {
+		source := source
+
+		source.listener.spawn(func() {
+			mux.fetch(fetchCtx, source, arrivals)
+		})
+	}
+
+	alive := len(mux.sources)
+
+	var runErr error
+
+	collect := func(delivery muxDelivery) {
+		if delivery.err == nil {
+			delivery.source.head = &delivery
+
+			return
+		}
+
+		alive--
+
+		if runErr == nil && !errors.Is(delivery.err, ErrShuttingDown) && !isCanceled(delivery.err) {
+			runErr = delivery.err
+		}
+	}
+
+	for {
+		// Wait for a message if none is waiting for its turn.
+		if !mux.hasHead() {
+			if alive == 0 {
+				return runErr
+			}
+
+			collect(<-arrivals)
+		}
+
+		// Collect the messages that arrived meanwhile, so they all compete for the turn.
+		for drained := false; !drained; {
+			select {
+			case delivery := <-arrivals:
+				collect(delivery)
+			default:
+				drained = true
+			}
+		}
+
+		source := mux.pick()
+		if source == nil {
+			continue
+		}
+
+		head := source.head
+		source.head = nil
+
+		// Let the source fetch its next message meanwhile, so it's ready for the next turn.
+		source.resume <- struct{}{}
+
+		head.ack(handler(ctx, head.message))
+	}
+}
+
+// hasHead reports whether a message is waiting for its turn.
+func (mux *Multiplexer) hasHead() bool {
+	for _, source := range mux.sources {
+		if source.head != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pick returns the source of the next message to process by smooth weighted round-robin among the
+// sources with a message waiting, nil if none has: every waiting source gains its weight, the one with
+// the highest current weight wins and loses the total weight of the waiting sources.
+func (mux *Multiplexer) pick() *muxSource {
+	var (
+		best  *muxSource
+		total int
+	)
+
+	for _, source := range mux.sources {
+		if source.head == nil {
+			continue
+		}
+
+		source.current += source.weight
+		total += source.weight
+
+		if best == nil || source.current > best.current {
+			best = source
+		}
+	}
+
+	if best != nil {
+		best.current -= total
+	}
+
+	return best
+}
+
+// Shutdown stops the fetches of Run and shuts down every Listener, which commits the messages
+// handled so far. It returns the first error of the Listeners' shutdowns.
+func (mux *Multiplexer) Shutdown(ctx context.Context) error {
+	mux.cancelMutex.Lock()
+	if mux.cancel != nil {
+		mux.cancel()
+	}
+	mux.cancelMutex.Unlock()
+
+	var shutdownErr error
+
+	for _, source := range mux.sources {
+		if err := source.listener.Shutdown(ctx); err != nil && shutdownErr == nil {
+			shutdownErr = errors.Wrap(err, "err := source.listener.Shutdown(ctx)")
+		}
+	}
+
+	return shutdownErr
+}
+
+// fetch fetches the messages of the source one at a time, waiting for each to be picked before
+// fetching the next one, until Next fails.
+func (mux *Multiplexer) fetch(ctx context.Context, source *muxSource, arrivals chan<- muxDelivery) {
+	for {
+		message, ack, err := source.listener.Next(ctx)
+		if err != nil {
+			arrivals <- muxDelivery{source: source, err: errors.Wrap(err, "message, ack, err := source.listener.Next(ctx)")}
+
+			return
+		}
+
+		arrivals <- muxDelivery{source: source, message: message, ack: ack}
+
+		<-source.resume
+	}
+}
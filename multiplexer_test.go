@@ -0,0 +1,77 @@
+package kafko_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/kafkotest"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMultiplexedListener creates a Listener of the given weight reading count messages of the topic.
+func newMultiplexedListener(topic string, weight, count int) (*kafko.Listener, *kafkotest.FakeReader) {
+	reader := kafkotest.NewFakeReader()
+
+	for offset := 0; offset < count; offset++ {
+		reader.Push(kafka.Message{Topic: topic, Offset: int64(offset)})
+	}
+
+	opts := kafko.NewOptionsListener().
+		WithWeight(weight).
+		WithReaderFactory(func() kafko.Reader {
+			return reader
+		})
+
+	return kafko.NewListener(log.NewLogger(), opts), reader
+}
+
+// TestMultiplexer checks that the Multiplexer gives the turns by weight while every Listener has
+// a backlog, processes every message and commits them.
+func TestMultiplexer(t *testing.T) {
+	t.Parallel()
+
+	high, highReader := newMultiplexedListener("high", 3, 8)
+	low, lowReader := newMultiplexedListener("low", 1, 8)
+	mux := kafko.NewMultiplexer(high, low)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handled := make(chan string, 16)
+
+	go func() {
+		topics := []string{}
+		for len(topics) < 16 {
+			topics = append(topics, <-handled)
+		}
+
+		// The high-priority topic gets most of the first turns, but doesn't starve the other one.
+		highTurns := 0
+
+		for _, topic := range topics[:8] {
+			if topic == "high" {
+				highTurns++
+			}
+		}
+
+		assert.GreaterOrEqual(t, highTurns, 5)
+		assert.Less(t, highTurns, 8)
+		assert.NoError(t, mux.Shutdown(ctx))
+	}()
+
+	assert.NoError(t, mux.Run(ctx, func(ctx context.Context, message kafka.Message) error {
+		// Give the fetches the time to refill, so both topics have a backlog.
+		time.Sleep(10 * time.Millisecond)
+
+		handled <- message.Topic
+
+		return nil
+	}))
+
+	assert.Len(t, highReader.Committed(), 8)
+	assert.Len(t, lowReader.Committed(), 8)
+}
@@ -0,0 +1,63 @@
+package kafko
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+var (
+	ErrOAuthBearerRejected = errors.New("the broker rejected the OAUTHBEARER token")
+)
+
+// TokenProvider returns the token used to authenticate through SASL/OAUTHBEARER.
+// The context is canceled when the connection attempt is, e.g. during the shutdown.
+type TokenProvider func(ctx context.Context) (string, error)
+
+// oauthBearer implements the SASL/OAUTHBEARER mechanism (RFC 7628) by asking the
+// TokenProvider for a token at every handshake.
+type oauthBearer struct {
+	tokenProvider TokenProvider
+}
+
+// Name returns the identifier of the mechanism.
+func (mechanism *oauthBearer) Name() string {
+	return "OAUTHBEARER"
+}
+
+// Start fetches a fresh token and returns the initial client response carrying it.
+func (mechanism *oauthBearer) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	token, err := mechanism.tokenProvider(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "token, err := mechanism.tokenProvider(ctx)")
+	}
+
+	return mechanism, []byte("n,,\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+// Next completes the handshake. The broker answers with an empty message on success and
+// with a challenge describing the error otherwise.
+func (mechanism *oauthBearer) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	if len(challenge) > 0 {
+		return false, nil, errors.Wrapf(ErrOAuthBearerRejected, "challenge = %s", challenge)
+	}
+
+	return true, nil, nil
+}
+
+// NewOAuthDialer creates a dialer authenticating through SASL/OAUTHBEARER over TLS. The token
+// provider is called at every connection, so reconnects always get a fresh token.
+func NewOAuthDialer(tokenProvider TokenProvider) *kafka.Dialer {
+	return &kafka.Dialer{
+		Timeout:       dialerTimeout,
+		DualStack:     true,
+		SASLMechanism: &oauthBearer{tokenProvider: tokenProvider},
+		TLS: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.NoClientCert,
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package kafko_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/m3co/kafko"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOAuthDialer checks that the OAUTHBEARER mechanism asks for a fresh token at every handshake.
+func TestOAuthDialer(t *testing.T) {
+	t.Parallel()
+
+	tokens := []string{"first", "second"}
+	calls := 0
+
+	dialer := kafko.NewOAuthDialer(func(ctx context.Context) (string, error) {
+		if calls == len(tokens) {
+			return "", errors.New("the token provider is unavailable") //nolint:goerr113
+		}
+
+		token := tokens[calls]
+		calls++
+
+		return token, nil
+	})
+
+	assert.Equal(t, "OAUTHBEARER", dialer.SASLMechanism.Name())
+
+	for _, token := range tokens {
+		session, initialResponse, err := dialer.SASLMechanism.Start(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "n,,\x01auth=Bearer "+token+"\x01\x01", string(initialResponse))
+
+		done, _, err := session.Next(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.True(t, done)
+	}
+
+	session, _, err := dialer.SASLMechanism.Start(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, session)
+}
@@ -0,0 +1,47 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// MessageHook is called with every message right before its delivery, see WithOnMessage.
+type MessageHook func(message kafka.Message)
+
+func nopMessageHook(kafka.Message) {}
+
+// observeMessages calls the message hook with every given message and counts their bytes, see
+// WithMetricBytesConsumed. A panic of the hook is recovered and logged, so it never affects the
+// processing of the message.
+func (listener *Listener) observeMessages(messages ...kafka.Message) {
+	for _, message := range messages {
+		listener.observeMessage(message)
+	}
+}
+
+// observeMessage calls the message hook with the message and counts its bytes like observeMessages.
+func (listener *Listener) observeMessage(message kafka.Message) {
+	listener.opts.metricBytesConsumed.Add(float64(len(message.Value)))
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			listener.log.Errorf(errors.Errorf("%v", recovered), "Recovered from a panic of the message hook (partition = %d, offset = %d)",
+				message.Partition, message.Offset)
+		}
+	}()
+
+	listener.opts.onMessage(message)
+}
+
+// observed returns deliver observing every message it receives, see observeMessage. It wraps the delivery
+// once the size limit, the deadline and the enricher accepted the message, so the messages they drop aren't
+// observed as delivered.
+func (listener *Listener) observed(deliver Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		listener.observeMessage(message)
+
+		return deliver(ctx, message)
+	}
+}
@@ -0,0 +1,172 @@
+package kafko
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrTopicMismatch = errors.New("the offsets were exported from another topic")
+)
+
+// OffsetWriter is implemented by readers able to set the offsets committed by their consumer
+// group, keyed by partition, see ImportOffsets.
+type OffsetWriter interface {
+	SetCommittedOffsets(ctx context.Context, offsets map[int]int64) error
+}
+
+// offsetsSnapshot is the format of ExportOffsets.
+type offsetsSnapshot struct {
+	Topic   string        `json:"topic,omitempty"`
+	Offsets map[int]int64 `json:"offsets"`
+}
+
+// CommitGroupOffsets commits the given offsets, keyed by partition, for the consumer group of the given
+// reader configuration, as the offsets the consumption resumes from. The group must have no active
+// member, e.g. a fresh group, as the offsets are committed from outside of the group.
+func CommitGroupOffsets(ctx context.Context, config kafka.ReaderConfig, offsets map[int]int64) error {
+	if config.GroupID == "" {
+		return ErrNoConsumerGroup
+	}
+
+	client := &kafka.Client{
+		Addr: kafka.TCP(config.Brokers...),
+	}
+
+	if transport := transportFromDialer(config.Dialer); transport != nil {
+		client.Transport = transport
+	}
+
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for partition, offset := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: partition, Offset: offset})
+	}
+
+	response, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      config.GroupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{config.Topic: commits},
+	})
+	if err != nil {
+		return errors.Wrap(err, "response, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{...})")
+	}
+
+	for _, partition := range response.Topics[config.Topic] {
+		if partition.Error != nil {
+			return errors.Wrapf(partition.Error, "partition.Error (partition.Partition = %d)", partition.Partition)
+		}
+	}
+
+	return nil
+}
+
+// ExportOffsets returns a snapshot of the offsets committed by the consumer group of the Listener,
+// e.g. to resume from them with ImportOffsets on the fresh group of a blue/green deployment.
+// Like CommittedOffsets, the reader must implement OffsetReader or be a *kafka.Reader.
+func (listener *Listener) ExportOffsets(ctx context.Context) ([]byte, error) {
+	offsets, err := listener.CommittedOffsets(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "offsets, err := listener.CommittedOffsets(ctx)")
+	}
+
+	snapshot := offsetsSnapshot{Offsets: offsets}
+
+	if reader, ok := listener.currentReader().(configReader); ok {
+		snapshot.Topic = reader.Config().Topic
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "data, err := json.Marshal(snapshot)")
+	}
+
+	return data, nil
+}
+
+// ImportGroupOffsets commits the offsets of a snapshot made by ExportOffsets for the consumer group of
+// the given reader configuration, so the consumption resumes from them. As a *kafka.Reader joins its
+// group once created, it must be called before creating the Listener, and the other members of the group
+// must be stopped. The partitions without a committed offset in the snapshot are left untouched.
+func ImportGroupOffsets(ctx context.Context, config kafka.ReaderConfig, data []byte) error {
+	snapshot, err := parseOffsetsSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Topic != "" && snapshot.Topic != config.Topic {
+		return errors.Wrapf(ErrTopicMismatch, "snapshot.Topic = %s, config.Topic = %s", snapshot.Topic, config.Topic)
+	}
+
+	return CommitGroupOffsets(ctx, config, snapshot.Offsets)
+}
+
+// ImportOffsets commits the offsets of a snapshot made by ExportOffsets for the consumer group of the
+// Listener, so Listen resumes from them. It must be called before Listen, otherwise ErrAlreadyListening
+// is returned. The partitions without a committed offset in the snapshot are left untouched.
+// The reader must either implement OffsetWriter or be a *kafka.Reader, otherwise ErrOffsetsUnsupported is returned.
+// A *kafka.Reader is closed first, so it leaves its group, and replaced by a new one from the
+// reader factory once the offsets are committed, see ImportGroupOffsets.
+func (listener *Listener) ImportOffsets(ctx context.Context, data []byte) error {
+	// Hold off Listen meanwhile, as the reader may be replaced.
+	if !listener.startListening() {
+		return ErrAlreadyListening
+	}
+
+	defer listener.stopListening()
+
+	switch reader := listener.currentReader().(type) {
+	case OffsetWriter:
+		snapshot, err := parseOffsetsSnapshot(data)
+		if err != nil {
+			return err
+		}
+
+		err = reader.SetCommittedOffsets(ctx, snapshot.Offsets)
+
+		return errors.Wrap(err, "err := reader.SetCommittedOffsets(ctx, snapshot.Offsets)")
+
+	case configReader:
+		config := reader.Config()
+
+		// Don't leave the group for a snapshot that can't be imported.
+		if _, err := parseOffsetsSnapshot(data); err != nil {
+			return err
+		}
+
+		if err := listener.closeReader(); err != nil {
+			return errors.Wrap(err, "err := listener.closeReader() (ImportOffsets)")
+		}
+
+		defer listener.openReader()
+
+		return ImportGroupOffsets(ctx, config, data)
+	}
+
+	return ErrOffsetsUnsupported
+}
+
+// parseOffsetsSnapshot decodes a snapshot made by ExportOffsets, without the partitions that had no
+// committed offset.
+func parseOffsetsSnapshot(data []byte) (offsetsSnapshot, error) {
+	var snapshot offsetsSnapshot
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return offsetsSnapshot{}, errors.Wrap(err, "err := json.Unmarshal(data, &snapshot)")
+	}
+
+	// A negative offset means the partition had no committed offset.
+	offsets := make(map[int]int64, len(snapshot.Offsets))
+
+	for partition, offset := range snapshot.Offsets {
+		if offset >= 0 {
+			offsets[partition] = offset
+		}
+	}
+
+	snapshot.Offsets = offsets
+
+	return snapshot, nil
+}
@@ -0,0 +1,206 @@
+package kafko_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/m3co/kafko/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/findcoordinator"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/offsetcommit"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCoordinator is a broker coordinating every consumer group, which records the offsets committed
+// from outside of the groups. It closes the connections sending other requests, e.g. to join a group.
+type fakeCoordinator struct {
+	listener net.Listener
+	host     string
+	port     int32
+
+	mutex     sync.Mutex
+	committed map[int]int64
+}
+
+func newFakeCoordinator(t *testing.T) *fakeCoordinator {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	coordinator := &fakeCoordinator{
+		listener:  listener,
+		host:      host,
+		port:      int32(portNumber),
+		committed: map[int]int64{},
+	}
+
+	go coordinator.accept()
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	return coordinator
+}
+
+func (coordinator *fakeCoordinator) addr() string {
+	return coordinator.listener.Addr().String()
+}
+
+func (coordinator *fakeCoordinator) offsets() map[int]int64 {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+
+	offsets := make(map[int]int64, len(coordinator.committed))
+	for partition, offset := range coordinator.committed {
+		offsets[partition] = offset
+	}
+
+	return offsets
+}
+
+func (coordinator *fakeCoordinator) accept() {
+	for {
+		conn, err := coordinator.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go coordinator.serve(conn)
+	}
+}
+
+func (coordinator *fakeCoordinator) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		apiVersion, correlationID, _, request, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+
+		var response protocol.Message
+
+		switch request := request.(type) {
+		case *apiversions.Request:
+			response = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+				{ApiKey: int16(protocol.Metadata), MinVersion: 1, MaxVersion: 1},
+				{ApiKey: int16(protocol.FindCoordinator), MinVersion: 0, MaxVersion: 2},
+				{ApiKey: int16(protocol.OffsetCommit), MinVersion: 2, MaxVersion: 2},
+			}}
+
+		case *metadata.Request:
+			response = &metadata.Response{Brokers: []metadata.ResponseBroker{
+				{NodeID: 0, Host: coordinator.host, Port: coordinator.port},
+			}}
+
+		case *findcoordinator.Request:
+			response = &findcoordinator.Response{NodeID: 0, Host: coordinator.host, Port: coordinator.port}
+
+		case *offsetcommit.Request:
+			response = coordinator.commit(request)
+
+		default:
+			return
+		}
+
+		if err := protocol.WriteResponse(conn, apiVersion, correlationID, response); err != nil {
+			return
+		}
+	}
+}
+
+func (coordinator *fakeCoordinator) commit(request *offsetcommit.Request) *offsetcommit.Response {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+
+	response := &offsetcommit.Response{}
+
+	for _, topic := range request.Topics {
+		partitions := make([]offsetcommit.ResponsePartition, 0, len(topic.Partitions))
+
+		for _, partition := range topic.Partitions {
+			coordinator.committed[int(partition.PartitionIndex)] = partition.CommittedOffset
+			partitions = append(partitions, offsetcommit.ResponsePartition{PartitionIndex: partition.PartitionIndex})
+		}
+
+		response.Topics = append(response.Topics, offsetcommit.ResponseTopic{Name: topic.Name, Partitions: partitions})
+	}
+
+	return response
+}
+
+// TestImportGroupOffsets checks that the offsets of a snapshot are committed for the consumer group
+// of a reader configuration, before any reader joins it.
+func TestImportGroupOffsets(t *testing.T) {
+	t.Parallel()
+
+	coordinator := newFakeCoordinator(t)
+	config := kafka.ReaderConfig{Brokers: []string{coordinator.addr()}, GroupID: "green", Topic: "orders"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	snapshot := []byte(`{"topic":"orders","offsets":{"0":5,"1":8,"2":-1}}`)
+
+	assert.NoError(t, kafko.ImportGroupOffsets(ctx, config, snapshot))
+	assert.Equal(t, map[int]int64{0: 5, 1: 8}, coordinator.offsets())
+
+	assert.ErrorIs(t, kafko.ImportGroupOffsets(ctx, config, []byte(`{"topic":"payments","offsets":{"0":1}}`)), kafko.ErrTopicMismatch)
+	assert.ErrorIs(t, kafko.ImportGroupOffsets(ctx, kafka.ReaderConfig{Topic: "orders"}, snapshot), kafko.ErrNoConsumerGroup)
+	assert.Error(t, kafko.ImportGroupOffsets(ctx, config, []byte("not json")))
+}
+
+// TestImportOffsetsKafkaReader checks that a Listener with a *kafka.Reader closes it, so it leaves
+// its group, before committing the offsets of a snapshot, and then consumes from a new reader.
+func TestImportOffsetsKafkaReader(t *testing.T) {
+	t.Parallel()
+
+	coordinator := newFakeCoordinator(t)
+	config := kafka.ReaderConfig{Brokers: []string{coordinator.addr()}, GroupID: "green", Topic: "orders"}
+
+	var readers []*kafka.Reader
+
+	opts := kafko.NewOptionsListener().
+		WithReaderFactory(func() kafko.Reader {
+			reader := kafka.NewReader(config)
+			readers = append(readers, reader)
+
+			return reader
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafko.NewListener(log.NewLogger(), opts)
+
+	assert.NoError(t, consumer.ImportOffsets(ctx, []byte(`{"topic":"orders","offsets":{"0":5,"1":8}}`)))
+	assert.Equal(t, map[int]int64{0: 5, 1: 8}, coordinator.offsets())
+
+	if assert.Len(t, readers, 2) {
+		_, err := readers[0].FetchMessage(ctx)
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	// The group isn't left for a snapshot that can't be imported.
+	assert.Error(t, consumer.ImportOffsets(ctx, []byte("not json")))
+	assert.Len(t, readers, 2)
+
+	assert.NoError(t, consumer.Shutdown(ctx))
+}
@@ -0,0 +1,140 @@
+package kafko
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrOffsetsUnsupported = errors.New("the reader cannot report committed offsets")
+	ErrNoConsumerGroup    = errors.New("the reader does not belong to a consumer group")
+)
+
+// OffsetReader is implemented by readers able to report the offsets committed by their
+// consumer group, keyed by partition.
+type OffsetReader interface {
+	CommittedOffsets(ctx context.Context) (map[int]int64, error)
+}
+
+// configReader is implemented by *kafka.Reader.
+type configReader interface {
+	Config() kafka.ReaderConfig
+}
+
+// transportFromDialer builds a kafka.Transport that authenticates like the given dialer,
+// or returns the pooled one if the dialer belongs to an open SharedDialer.
+func transportFromDialer(dialer *kafka.Dialer) *kafka.Transport {
+	if dialer == nil {
+		return nil
+	}
+
+	if transport, ok := sharedTransports.Load(dialer); ok {
+		return transport.(*kafka.Transport) //nolint:forcetypeassert // Only transports are stored.
+	}
+
+	return &kafka.Transport{
+		DialTimeout: dialer.Timeout,
+		ClientID:    dialer.ClientID,
+		TLS:         dialer.TLS,
+		SASL:        dialer.SASLMechanism,
+	}
+}
+
+// FetchCommittedOffsets queries the group coordinator for the offsets committed by the consumer
+// group of the given reader configuration, keyed by partition. A negative offset means the
+// partition has no committed offset yet.
+func FetchCommittedOffsets(ctx context.Context, config kafka.ReaderConfig) (map[int]int64, error) {
+	if config.GroupID == "" {
+		return nil, ErrNoConsumerGroup
+	}
+
+	client := &kafka.Client{
+		Addr: kafka.TCP(config.Brokers...),
+	}
+
+	if transport := transportFromDialer(config.Dialer); transport != nil {
+		client.Transport = transport
+	}
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{config.Topic}})
+	if err != nil {
+		return nil, errors.Wrap(err, "metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{...})")
+	}
+
+	partitions := []int{}
+
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return nil, errors.Wrapf(topic.Error, "topic.Error (topic.Name = %s)", topic.Name)
+		}
+
+		for _, partition := range topic.Partitions {
+			partitions = append(partitions, partition.ID)
+		}
+	}
+
+	response, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: config.GroupID,
+		Topics:  map[string][]int{config.Topic: partitions},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "response, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{...})")
+	}
+
+	if response.Error != nil {
+		return nil, errors.Wrap(response.Error, "response.Error")
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+
+	for _, partition := range response.Topics[config.Topic] {
+		if partition.Error != nil {
+			return nil, errors.Wrapf(partition.Error, "partition.Error (partition.Partition = %d)", partition.Partition)
+		}
+
+		offsets[partition.Partition] = partition.CommittedOffset
+	}
+
+	return offsets, nil
+}
+
+// CommittedOffsets returns the offsets committed by the consumer group of the Listener, keyed by partition.
+// The reader must either implement OffsetReader or be a *kafka.Reader, otherwise ErrOffsetsUnsupported is returned.
+// It's safe to call while the Listener reconnects.
+func (listener *Listener) CommittedOffsets(ctx context.Context) (map[int]int64, error) {
+	switch reader := listener.currentReader().(type) {
+	case OffsetReader:
+		offsets, err := reader.CommittedOffsets(ctx)
+
+		return offsets, errors.Wrap(err, "offsets, err := reader.CommittedOffsets(ctx)")
+
+	case configReader:
+		return FetchCommittedOffsets(ctx, reader.Config())
+	}
+
+	return nil, ErrOffsetsUnsupported
+}
+
+// logCommittedOffsets logs where the consumption resumes on every partition.
+func (listener *Listener) logCommittedOffsets(ctx context.Context) {
+	offsets, err := listener.CommittedOffsets(ctx)
+	if err != nil {
+		listener.log.Errorf(err, "err := listener.CommittedOffsets(ctx)")
+
+		return
+	}
+
+	partitions := make([]int, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+
+	sort.Ints(partitions)
+
+	for _, partition := range partitions {
+		listener.log.Printf("resuming from offset %d on partition %d", offsets[partition], partition)
+	}
+}
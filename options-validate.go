@@ -0,0 +1,137 @@
+package kafko
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrIncompatibleOptions         = errors.New("incompatible options")
+	ErrStaticMembershipUnsupported = errors.New("static group membership is not supported by kafka-go")
+)
+
+// Validate checks the options for the combinations that would silently misbehave and returns
+// ErrIncompatibleOptions describing the first one found. It catches:
+//   - WithKeyAffinity without WithOrderedConcurrency(n) with n > 1, as the messages are then processed
+//     one at a time anyway;
+//   - WithCheckpointOnAssign with WithCommitOnShutdownOnly, as the former commits during the processing
+//     the latter avoids;
+//   - WithReaderHealthProbe with WithPrefetch, as the prefetched fetches aren't probed, so the probe
+//     never trips;
+//   - WithCommitMode(CommitBeforeProcess) with WithCommitOnShutdownOnly, as the former commits on fetch;
+//   - WithBatchByKey with WithBatchDelivery, as the batches are either bounded by keys or by messages;
+//   - WithRecommitJitter without the commit loop, e.g. with WithoutRecommitLoop, as there's no periodic
+//     commit to jitter;
+//   - WithMetricUncommittedDepth or WithUncommittedWarnThreshold without the commit loop, e.g. with
+//     WithCommitOnShutdownOnly, as the commit loop samples the uncommitted messages;
+//   - WithBatchDelivery or WithBatchByKey with an option handling the messages one at a time, see
+//     perMessageOption, as the batches are delivered as they were fetched.
+//
+// It also returns ErrUnsupportedCompression if the codec set by WithExpectedCompression isn't supported,
+// and ErrStaticMembershipUnsupported if WithConsumerGroupInstanceID is set.
+// NewListener panics if the merge of its options is invalid, and if its reader doesn't implement
+// GenerationReader while an option relying on it is set, see generationOption.
+func (opts *OptionsListener) Validate() error {
+	if opts.keyAffinity && opts.orderedConcurrency <= 1 {
+		return errors.Wrapf(ErrIncompatibleOptions, "WithKeyAffinity requires WithOrderedConcurrency(n) with n > 1 (n = %d)", opts.orderedConcurrency)
+	}
+
+	if opts.checkpointOnAssign && opts.commitOnShutdownOnly {
+		return errors.Wrap(ErrIncompatibleOptions, "WithCheckpointOnAssign commits during the processing, which WithCommitOnShutdownOnly avoids")
+	}
+
+	if opts.maxFetchLatency > 0 && opts.prefetch > 0 {
+		return errors.Wrap(ErrIncompatibleOptions, "WithReaderHealthProbe doesn't probe the fetches of WithPrefetch")
+	}
+
+	if opts.commitMode == CommitBeforeProcess && opts.commitOnShutdownOnly {
+		return errors.Wrap(ErrIncompatibleOptions, "CommitBeforeProcess commits on fetch, which WithCommitOnShutdownOnly avoids")
+	}
+
+	if opts.batchMaxKeys > 0 && opts.batchMaxSize > 0 {
+		return errors.Wrap(ErrIncompatibleOptions, "WithBatchByKey and WithBatchDelivery bound the batches differently")
+	}
+
+	if opts.recommitJitter > 0 && opts.withoutRecommitLoop {
+		return errors.Wrap(ErrIncompatibleOptions, "WithRecommitJitter requires the commit loop, disabled by WithoutRecommitLoop")
+	}
+
+	if opts.samplesUncommitted() && opts.withoutRecommitLoop {
+		return errors.Wrap(ErrIncompatibleOptions, "WithMetricUncommittedDepth and WithUncommittedWarnThreshold are sampled by the commit loop, disabled by WithoutRecommitLoop")
+	}
+
+	if option := opts.perMessageOption(); option != "" && (opts.batchMaxSize > 0 || opts.batchMaxKeys > 0) {
+		return errors.Wrapf(ErrIncompatibleOptions, "%s doesn't apply to the batches of WithBatchDelivery and WithBatchByKey", option)
+	}
+
+	if opts.groupInstanceID != "" {
+		return errors.Wrapf(ErrStaticMembershipUnsupported, "WithConsumerGroupInstanceID(%q)", opts.groupInstanceID)
+	}
+
+	if opts.expectedCompression != 0 {
+		if err := checkCompression(opts.expectedCompression); err != nil {
+			return errors.Wrap(err, "err := checkCompression(opts.expectedCompression)")
+		}
+	}
+
+	return nil
+}
+
+// perMessageOption returns the name of the first option set that only applies to the messages delivered
+// one at a time, empty if none is.
+func (opts *OptionsListener) perMessageOption() string {
+	switch {
+	case opts.tombstoneHandler != nil:
+		return "WithTombstoneHandler"
+	case opts.enricher != nil:
+		return "WithEnricher"
+	case opts.maxMessageSize > 0:
+		return "WithMaxMessageSize"
+	case opts.deadlineFromMessageTime > 0:
+		return "WithDeadlineFromMessageTime"
+	case opts.timeoutHeader != "":
+		return "WithTimeoutHeader"
+	}
+
+	return ""
+}
+
+// generationOption returns the name of the first option set that relies on the reader implementing
+// GenerationReader, empty if none is.
+func (opts *OptionsListener) generationOption() string {
+	switch {
+	case opts.onPartitionsRevoked != nil:
+		return "WithOnPartitionsRevoked"
+	case opts.onPartitionsAssigned != nil:
+		return "WithOnPartitionsAssigned"
+	case opts.metricRebalances != nil:
+		return "WithMetricRebalances"
+	case opts.metricMessagesReleased != nil:
+		return "WithMetricMessagesReleased"
+	}
+
+	return ""
+}
+
+// samplesUncommitted reports whether WithMetricUncommittedDepth or WithUncommittedWarnThreshold is set.
+func (opts *OptionsListener) samplesUncommitted() bool {
+	_, nop := opts.metricUncommittedDepth.(*nopGauge)
+
+	return (opts.metricUncommittedDepth != nil && !nop) || opts.uncommittedWarnThreshold > 0
+}
+
+// validateReader returns ErrIncompatibleOptions if one of the given options relies on GenerationReader,
+// which the reader, e.g. a *kafka.Reader, doesn't implement, as the option would never apply.
+// The options are checked before their merge, which sets the defaults of the hooks and the metrics.
+func validateReader(reader Reader, opts []*OptionsListener) error {
+	if _, ok := reader.(GenerationReader); ok {
+		return nil
+	}
+
+	for _, opt := range opts {
+		if option := opt.generationOption(); option != "" {
+			return errors.Wrapf(ErrIncompatibleOptions, "%s requires a reader implementing GenerationReader (reader = %T)", option, reader)
+		}
+	}
+
+	return nil
+}
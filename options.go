@@ -1,6 +1,8 @@
 package kafko
 
 import (
+	"context"
+	"math"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -9,6 +11,8 @@ import (
 type ReaderFactory func() Reader
 type WriterFactory func() Writer
 
+// Incrementer is a counter metric. Inc is called synchronously from the processing loop,
+// so implementations must be cheap and must not block.
 type Incrementer interface {
 	Inc()
 }
@@ -17,6 +21,18 @@ type nopIncrementer struct{}
 
 func (n *nopIncrementer) Inc() {}
 
+// Adder is a counter metric increased by a given amount, e.g. a number of bytes. Like Incrementer,
+// Add is called synchronously and must not block.
+type Adder interface {
+	Add(float64)
+}
+
+type nopAdder struct{}
+
+func (n *nopAdder) Add(float64) {}
+
+// Duration is a histogram metric. Like Incrementer, Observe is called synchronously
+// and must not block.
 type Duration interface {
 	Observe(float64)
 }
@@ -25,8 +41,23 @@ type nopDuration struct{}
 
 func (n *nopDuration) Observe(float64) {}
 
+// Gauge is a metric reporting a current value. Like Incrementer, Set is called synchronously
+// and must not block.
+type Gauge interface {
+	Set(float64)
+}
+
+type nopGauge struct{}
+
+func (n *nopGauge) Set(float64) {}
+
+// TimeoutHook is called with a message whose processing timed out and the time elapsed since its delivery started.
+type TimeoutHook func(message kafka.Message, elapsed time.Duration)
+
+func nopTimeoutHook(kafka.Message, time.Duration) {}
+
 // defaultProcessDroppedMsg logs a dropped message and returns a predefined error.
-func defaultProcessDroppedMsg(msg *kafka.Message, log Logger) error {
+func defaultProcessDroppedMsg(_ context.Context, msg *kafka.Message, log Logger) error {
 	// Log the dropped message with its content.
 	log.Errorf(ErrMessageDropped, "msg = %s, key = %s, topic = %s, partition = %d, offset = %d", string(msg.Value), string(msg.Key), msg.Topic, msg.Partition, msg.Offset)
 
@@ -36,22 +67,159 @@ func defaultProcessDroppedMsg(msg *kafka.Message, log Logger) error {
 
 // OptionsListener is a configuration struct for a Kafka consumer.
 type OptionsListener struct {
+	name              string                   // Name used to label logs and stats of the listener.
 	recommitTicker    *time.Ticker             // Time interval between attempts to commit uncommitted messages.
+	recommitInterval  time.Duration            // Interval of recommitTicker, used to jitter the attempts.
+	recommitJitter    float64                  // Fraction of recommitInterval randomly added or removed to every attempt.
 	reconnectInterval time.Duration            // Time interval between reconnect attempts.
+	initialBackoff    time.Duration            // First reconnect delay, doubled on every failure. Zero uses reconnectInterval.
+	maxBackoff        time.Duration            // Maximum reconnect delay, zero caps it to reconnectInterval.
 	processingTimeout time.Duration            // Maximum allowed time for processing a message.
+	shutdownTimeout   time.Duration            // Maximum time Shutdown takes when its ctx has no deadline.
+	commitTimeout     time.Duration            // Maximum time of the final commit of the commit loop.
 	processDroppedMsg ProcessDroppedMsgHandler // Handler function to process dropped messages.
 	readerFactory     ReaderFactory            // Factory function to create Reader instances.
-
+	groupInstanceID   string                   // Static membership id of the consumer, unsupported, see WithConsumerGroupInstanceID.
+	committer         Committer                // Committer of the processed messages, nil commits through the reader.
+	secondary         Committer                // Committer of the offsets to a mirror, after the committer, see WithSecondaryCommitter.
+	batchMaxSize      int                      // Maximum number of messages per batch, zero disables batch delivery.
+	batchMaxWait      time.Duration            // Maximum time to wait for a batch to fill up.
+	batchMaxKeys      int                      // Maximum number of keys per batch, zero disables the batching by key.
+
+	orderedConcurrency int           // Number of messages ListenFunc processes concurrently, committed in fetch order.
+	prefetch           int           // Number of messages fetched ahead of the processing, zero disables it.
+	dropSemantics      DropSemantics // Who owns a dropped message, see DropSemantics for the default.
+	commitMode         CommitMode    // When the messages are committed, after their processing by default.
+	weight             int           // Share of the turns of the Listener in a Multiplexer, 1 by default.
+	keyAffinity        bool          // Whether the messages with the same key are processed sequentially.
+	processingSlots    int           // Number of messages processed at once, across the workers, unlimited if 0.
+
+	commitInFlightOnShutdown bool // Whether Shutdown waits for the in-flight acknowledgement and commits it.
+	logCommittedOffsets      bool // Whether Listen logs the committed offsets before consuming.
+	withoutRecommitLoop      bool // Whether the commit loop is disabled.
+	commitOnShutdownOnly     bool // Whether the processed messages are only committed by Shutdown.
+	uncommittedWarnThreshold int  // Number of uncommitted messages over which a warning is logged, none if 0.
+	unbufferedAck            bool // Whether the messages and their acknowledgements are handed off synchronously.
+	skipEmptyMessages        bool // Whether the messages without value are skipped.
+	checkpointOnAssign       bool // Whether the position of every newly assigned partition is committed on its first fetch.
+
+	routingHeader string             // Header used by ListenFunc to dispatch the messages.
+	routes        map[string]Handler // Handlers by value of the routing header.
+	headersOnly   bool               // Whether ListenFunc hands the messages to the handlers without their value.
+
+	tombstoneHandler Handler  // Handler of the messages with a nil value, see WithTombstoneHandler.
+	maxMessageSize   int      // Size of the biggest value delivered, in bytes, unlimited if 0.
+	enricher         Enricher // Function completing the messages before their delivery, see WithEnricher.
+
+	maxMessageAge time.Duration    // Age over which a message is skipped as stale, unlimited if 0.
+	now           func() time.Time // Clock measuring the age of the messages, time.Now by default.
+
+	deadlineFromMessageTime time.Duration // Time after the message time by which it must be processed, unlimited if 0.
+
+	maxFetchLatency time.Duration // Fetch latency over which the reader is deemed degraded, see WithReaderHealthProbe.
+
+	expectedCompression kafka.Compression // Compression of the topic checked by Validate, none if 0.
+
+	memThreshold     uint64        // Heap size, in bytes, over which the fetches are delayed, unlimited if 0.
+	throttleInterval time.Duration // Time interval between the checks of the heap size.
+	throttleDelay    time.Duration // Delay inserted before every fetch while the heap exceeds memThreshold.
+
+	retryTiers  []retryTier // Retry topics sorted by delay, see WithRetryTopics.
+	retryWriter Writer      // Writer of the messages republished to the retry topics.
+
+	republishHeaders RepublishHeaders // Headers added to the messages republished to the dead-letter or retry topics.
+
+	timeoutHeader          string                   // Header extending the processing timeout of a message, in milliseconds.
+	maxProcessingTimeout   time.Duration            // Cap of the processing timeout set by timeoutHeader.
+	topicProcessingTimeout map[string]time.Duration // Processing timeouts by topic, overriding processingTimeout.
+
+	deliveryTimeout time.Duration // Maximum wait for the consumer to receive a message, processingTimeout if unset.
+	ackTimeout      time.Duration // Maximum wait for the acknowledgement of a message, processingTimeout if unset.
+	handlerTimeout  time.Duration // Time after which the context of the handler of ListenFunc is canceled, unlimited if 0.
+
+	onTimeout TimeoutHook // Hook called when the processing of a message times out.
+	onOutcome OutcomeHook // Hook called with the fate of every message.
+	onMessage MessageHook // Hook called with every message before its delivery.
+
+	onPartitionsRevoked  PartitionsHook // Hook called with the partitions revoked by a rebalance.
+	onPartitionsAssigned PartitionsHook // Hook called with the partitions assigned by a rebalance.
+
+	metricMessagesFetched   Incrementer // Incrementer for the number of fetched messages.
+	metricBytesConsumed     Adder       // Adder for the size, in bytes, of the values of the delivered messages.
+	metricMessagesOversized Incrementer // Incrementer for the number of messages dropped by WithMaxMessageSize.
+	metricMessagesStale     Incrementer // Incrementer for the number of messages skipped by WithMaxMessageAge.
 	metricMessagesProcessed Incrementer // Incrementer for the number of processed messages.
-	metricMessagesDropped   Incrementer // Incrementer for the number of dropped messages.
+	metricMessagesDropped   Incrementer // Incrementer for the number of dropped messages the handler failed to process.
+	metricMessagesRescued   Incrementer // Incrementer for the number of dropped messages the handler processed.
+	metricMessagesReleased  Incrementer // Incrementer for the number of uncommitted messages of partitions no longer assigned.
 	metricErrors            Incrementer // Incrementer for the number of Kafka errors.
 	metricDurationProcess   Duration
+	metricLastCommittedTime Gauge       // Gauge for the Unix time, in seconds, of the last committed message.
+	metricReconnectDuration Duration    // Histogram for the duration, in milliseconds, of the reconnections.
+	metricReconnects        Incrementer // Incrementer for the number of successful reconnections.
+	metricReconnectFailures Incrementer // Incrementer for the number of failed reconnections.
+	metricAckLatency        Duration    // Histogram for the time, in milliseconds, from delivery to acknowledgement.
+	metricHealthProbeSwaps  Incrementer // Incrementer for the number of readers swapped by WithReaderHealthProbe.
+	metricRebalances        Incrementer // Incrementer for the number of rebalances, see GenerationReader.
+	metricFetchLatency      Duration    // Histogram for the time, in milliseconds, spent fetching a message from Kafka.
+	metricCommitLatency     Duration    // Histogram for the time, in milliseconds, spent committing messages to Kafka.
+	metricHandlerTimeouts   Incrementer // Incrementer for the number of handlers whose context was canceled by WithHandlerTimeout.
+	metricSecondaryErrors   Incrementer // Incrementer for the number of failed commits of WithSecondaryCommitter.
+	metricUncommittedDepth  Gauge       // Gauge for the highest number of uncommitted messages since the last commit.
+}
+
+// WithName sets the name used to prefix log lines and to label the listener's Stats.
+// It allows distinguishing several listeners hosted by the same process.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithName(name string) *OptionsListener {
+	opts.name = name
+
+	return opts
 }
 
 // WithRecommitInterval sets the commit interval for the Options instance.
+// A zero or negative interval disables the commit loop, see WithoutRecommitLoop.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithRecommitInterval(recommitInterval time.Duration) *OptionsListener {
+	if recommitInterval <= 0 {
+		return opts.WithoutRecommitLoop()
+	}
+
 	opts.recommitTicker = time.NewTicker(recommitInterval)
+	opts.recommitInterval = recommitInterval
+
+	return opts
+}
+
+// WithoutRecommitLoop disables the loop periodically retrying to commit the uncommitted messages.
+// Every message is still committed once processed, so the loop is redundant when those commits
+// are expected to succeed. The messages left uncommitted by a failed commit are committed along
+// with the next processed message or by Flush and Shutdown.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithoutRecommitLoop() *OptionsListener {
+	opts.withoutRecommitLoop = true
+
+	return opts
+}
+
+// WithCommitOnShutdownOnly saves the commits during the processing: the offsets of the processed messages
+// are only committed by Shutdown, or Flush, and the commit loop is disabled. Only for idempotent consumers,
+// as a crash redelivers every message processed since the start of the listener. Only the highest offset
+// of every partition is kept in memory. The commits before revoking the partitions of a rebalance are kept.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCommitOnShutdownOnly() *OptionsListener {
+	opts.commitOnShutdownOnly = true
+
+	return opts.WithoutRecommitLoop()
+}
+
+// WithRecommitJitter randomly shifts every commit attempt by up to the given fraction of the
+// commit interval, e.g. 0.1 spreads the attempts over ±10% of the interval. It keeps the listeners
+// started at once (like during a rolling deploy) from committing in sync. The fraction is capped to 0.5,
+// so the commit loop never spins on a near zero interval.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithRecommitJitter(fraction float64) *OptionsListener {
+	opts.recommitJitter = fraction
 
 	return opts
 }
@@ -64,6 +232,26 @@ func (opts *OptionsListener) WithReconnectInterval(reconnectInterval time.Durati
 	return opts
 }
 
+// WithInitialBackoff sets the delay before the first reconnection after a recoverable Kafka error.
+// The delay doubles on every consecutive failure, up to the maximum backoff, and resets once a fetch
+// succeeds, so a blip recovers quickly while an outage is retried slowly. Without it, every
+// reconnection waits for the reconnect interval.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithInitialBackoff(backoff time.Duration) *OptionsListener {
+	opts.initialBackoff = backoff
+
+	return opts
+}
+
+// WithMaxBackoff sets the maximum delay between reconnections, see WithInitialBackoff.
+// It defaults to the reconnect interval.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMaxBackoff(backoff time.Duration) *OptionsListener {
+	opts.maxBackoff = backoff
+
+	return opts
+}
+
 // WithProcessingTimeout sets the processing timeout for the Options instance.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithProcessingTimeout(processingTimeout time.Duration) *OptionsListener {
@@ -72,6 +260,25 @@ func (opts *OptionsListener) WithProcessingTimeout(processingTimeout time.Durati
 	return opts
 }
 
+// WithShutdownTimeout sets the maximum time Shutdown takes when the given ctx has no deadline.
+// Past it, the reader is force closed and Shutdown returns ErrShutdownTimeout. Defaults to 30s.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithShutdownTimeout(shutdownTimeout time.Duration) *OptionsListener {
+	opts.shutdownTimeout = shutdownTimeout
+
+	return opts
+}
+
+// WithCommitTimeout sets the maximum time of the final commit of the commit loop. It doesn't use the
+// context given to Listen, which is done by then, so the offsets are persisted even once it's canceled.
+// During a shutdown, it's also bounded by the context given to Shutdown. Defaults to 5s.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCommitTimeout(timeout time.Duration) *OptionsListener {
+	opts.commitTimeout = timeout
+
+	return opts
+}
+
 func (opts *OptionsListener) WithDurationProcess(metric Duration) *OptionsListener {
 	opts.metricDurationProcess = metric
 
@@ -91,6 +298,483 @@ func (opts *OptionsListener) WithProcessDroppedMsg(processDroppedMsg ProcessDrop
 	return opts
 }
 
+// WithDropToFile sets a dropped message handler that writes every dropped message to its own file
+// in dir, encoded by EncodeMessage, so it can be replayed later. It replaces WithProcessDroppedMsg.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithDropToFile(dir string) *OptionsListener {
+	opts.processDroppedMsg = dropToFile(dir)
+
+	return opts
+}
+
+// WithWeight sets the share of the turns the Listener gets in a Multiplexer while the other Listeners
+// have messages ready too, e.g. 3 for a high-priority topic against 1 for the others (1 by default).
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithWeight(weight int) *OptionsListener {
+	opts.weight = weight
+
+	return opts
+}
+
+// WithCommitMode sets when the messages are committed. With CommitBeforeProcess, a message is committed
+// as soon as it's fetched, before its delivery, so a crash while processing it loses it instead of
+// redelivering it, e.g. for low-value data that must never be processed twice. A message whose commit
+// fails isn't delivered. The acknowledgements, and the dropped message handler, then don't commit anything.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCommitMode(mode CommitMode) *OptionsListener {
+	opts.commitMode = mode
+
+	return opts
+}
+
+// WithDropSemantics sets who owns a dropped message: with DropTerminal the dropped message handler
+// does and the message is committed once it succeeds, with DropRedeliver the handler is not called
+// and the message is left uncommitted to be redelivered, and with DropInformational the handler is
+// called but the message is never committed. By default, only the messages whose processing timed
+// out are committed once the handler succeeds.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithDropSemantics(semantics DropSemantics) *OptionsListener {
+	opts.dropSemantics = semantics
+
+	return opts
+}
+
+// WithBatchDelivery enables the batch delivery mode. Fetched messages are accumulated until
+// maxSize messages are collected or maxWait elapses, and delivered as a unit through the
+// channel returned by BatchAndErrorChannels. The batch is committed as a unit, see PartialBatchError.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithBatchDelivery(maxSize int, maxWait time.Duration) *OptionsListener {
+	opts.batchMaxSize = maxSize
+	opts.batchMaxWait = maxWait
+
+	return opts
+}
+
+// WithBatchByKey enables the batch delivery mode grouping the messages by key, e.g. for the bulk
+// upserts of a database. Fetched messages are accumulated until they hold maxKeys distinct keys or
+// maxWait elapses, and delivered through the channel returned by BatchAndErrorChannels sorted so
+// the messages sharing a key are consecutive, see GroupByKey. The batch is committed as a unit and,
+// on a PartialBatchError, a message is only committed if no message of its partition with a lower
+// offset is left unprocessed.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithBatchByKey(maxKeys int, maxWait time.Duration) *OptionsListener {
+	opts.batchMaxKeys = maxKeys
+	opts.batchMaxWait = maxWait
+
+	return opts
+}
+
+// WithSkipEmptyMessages makes the listener skip the messages without value, like the phantom
+// messages some control records produce, instead of delivering them. The skipped messages are
+// reported as OutcomeFiltered and their offsets are committed along with the next processed message.
+// Without WithTombstoneHandler, the tombstones of compacted topics are skipped too, as they have no value.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithSkipEmptyMessages(skip bool) *OptionsListener {
+	opts.skipEmptyMessages = skip
+
+	return opts
+}
+
+// WithCheckpointOnAssign makes the listener commit, synchronously, the position of the first message
+// fetched from every partition since the last rebalance or reconnection before processing it. It bounds
+// the reprocessing after a crash of the low-traffic partitions, which otherwise restart from the reset
+// offset of the reader until their first message is processed. The committed message is the one right
+// before the fetched one, so a Committer may receive an offset of -1.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCheckpointOnAssign() *OptionsListener {
+	opts.checkpointOnAssign = true
+
+	return opts
+}
+
+// WithOrderedConcurrency makes ListenFunc call the handler with up to n messages concurrently.
+// The messages are still committed in fetch order: a message is only committed once every message
+// fetched before it was processed, so a crash never skips an unprocessed offset.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOrderedConcurrency(n int) *OptionsListener {
+	opts.orderedConcurrency = n
+
+	return opts
+}
+
+// WithKeyAffinity makes WithOrderedConcurrency process the messages with the same key in fetch order,
+// one at a time, while the messages with different keys are still processed concurrently. The keys are
+// hashed to a fixed number of lanes, one per concurrent message, so different keys may share a lane.
+// The messages without key aren't ordered. The commits still follow the fetch order.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithKeyAffinity() *OptionsListener {
+	opts.keyAffinity = true
+
+	return opts
+}
+
+// WithProcessingSemaphore caps the number of messages processed at once by the Listener to n, from their
+// fetch until their acknowledgement, however they are distributed: across the WithOrderedConcurrency
+// workers and the messages returned by Next and not acknowledged yet. It protects a shared downstream of
+// fixed capacity, e.g. a connection pool, independently of the number of workers. Waiting for a slot
+// honors the ctx of Listen, ListenFunc or Next.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithProcessingSemaphore(n int) *OptionsListener {
+	opts.processingSlots = n
+
+	return opts
+}
+
+// WithPrefetch makes the Listener fetch up to n messages ahead of the processing in its own goroutine,
+// so the processing doesn't wait for the network round-trips. The messages are still processed and
+// committed in fetch order. The prefetched messages left when the Listener stops are redelivered.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithPrefetch(n int) *OptionsListener {
+	opts.prefetch = n
+
+	return opts
+}
+
+// WithExpectedCompression declares the compression codec the producers of the topic use, e.g.
+// kafka.Snappy, so Validate, and thus NewListener, checks at startup that the codec is supported
+// instead of failing on the first compressed batch. kafka-go builds in gzip, snappy, lz4 and zstd,
+// so no codec has to be imported or registered.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithExpectedCompression(codec kafka.Compression) *OptionsListener {
+	opts.expectedCompression = codec
+
+	return opts
+}
+
+// WithAdaptiveThrottle slows down the fetches while the heap of the process exceeds memThresholdBytes,
+// e.g. when the sink falls behind, to avoid running out of memory. The heap is checked periodically,
+// see WithThrottleInterval, and every fetch is delayed while it exceeds the threshold, see WithThrottleDelay.
+// The delay is cut short once the context of Listen is done.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithAdaptiveThrottle(memThresholdBytes uint64) *OptionsListener {
+	opts.memThreshold = memThresholdBytes
+
+	return opts
+}
+
+// WithThrottleInterval sets how often WithAdaptiveThrottle checks the heap size (1s by default).
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithThrottleInterval(interval time.Duration) *OptionsListener {
+	opts.throttleInterval = interval
+
+	return opts
+}
+
+// WithThrottleDelay sets the delay WithAdaptiveThrottle inserts before every fetch while the heap
+// exceeds the threshold (100ms by default).
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithThrottleDelay(delay time.Duration) *OptionsListener {
+	opts.throttleDelay = delay
+
+	return opts
+}
+
+// WithUnbufferedAck makes the message, batch, error and acknowledgement channels unbuffered, so every
+// delivery and acknowledgement is a synchronous handoff. The acknowledgement a consumer sends after the
+// processing of its message timed out is then discarded instead of lingering in the channel and being
+// attributed to the next message. It assumes a single consumer goroutine. The consumer is more tightly
+// coupled to the listener, which may lower the throughput.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithUnbufferedAck() *OptionsListener {
+	opts.unbufferedAck = true
+
+	return opts
+}
+
+// WithCommitInFlightOnShutdown makes Shutdown wait for the acknowledgement of the message
+// being processed when the shutdown starts, bounded by the context given to Shutdown, and commit it.
+// This avoids reprocessing the last message after a graceful restart at the cost of a slower
+// shutdown: without it, the wait is bounded by the processing timeout and an unacknowledged
+// message is redelivered.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCommitInFlightOnShutdown() *OptionsListener {
+	opts.commitInFlightOnShutdown = true
+
+	return opts
+}
+
+// WithLogCommittedOffsets makes Listen log the offsets committed by the consumer group,
+// i.e. where the consumption resumes on every partition, before fetching any message.
+// See Listener.CommittedOffsets for the readers supporting it.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithLogCommittedOffsets() *OptionsListener {
+	opts.logCommittedOffsets = true
+
+	return opts
+}
+
+// WithHeaderRouter makes ListenFunc dispatch every message to the handler of routes matching the
+// value of the given header, e.g. "event-type". Unmatched messages fall to the handler given to
+// ListenFunc or, if it's nil, to the dropped message handler. The commit follows the return of the chosen handler.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithHeaderRouter(header string, routes map[string]Handler) *OptionsListener {
+	opts.routingHeader = header
+	opts.routes = routes
+
+	return opts
+}
+
+// WithHeadersOnly makes ListenFunc hand the messages to the handler, or to the routes of WithHeaderRouter,
+// without their value, for the routing tiers deciding where to forward a message from its headers only.
+// The reader still fetches the whole messages, but the handlers can't hold on to their values. The checks
+// of the value, e.g. WithTombstoneHandler or WithMaxMessageSize, are done beforehand, and the dropped
+// message handler still receives the whole message.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithHeadersOnly() *OptionsListener {
+	opts.headersOnly = true
+
+	return opts
+}
+
+// WithTombstoneHandler makes the listener process the tombstones of compacted topics, i.e. the messages
+// with a nil value, with the given handler instead of delivering them. The tombstone is committed if the
+// handler returns nil, otherwise it's processed as a dropped message. WithSkipEmptyMessages doesn't skip
+// the tombstones once a tombstone handler is set. In batch mode the tombstones are still delivered
+// in the batches, see IsTombstone.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithTombstoneHandler(handler Handler) *OptionsListener {
+	opts.tombstoneHandler = handler
+
+	return opts
+}
+
+// WithDeliveryTimeout sets how long the delivery of a message waits for the consumer to receive it,
+// i.e. the backpressure tolerated before the message is dropped, so a stalled consumer is detected
+// promptly even with a long WithAckTimeout. It defaults to the processing timeout.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithDeliveryTimeout(timeout time.Duration) *OptionsListener {
+	opts.deliveryTimeout = timeout
+
+	return opts
+}
+
+// WithAckTimeout sets how long the acknowledgement of a delivered message is waited for, i.e. the
+// processing slowness tolerated before the message is dropped. Like the processing timeout, it can be
+// extended by WithTimeoutHeader. It defaults to the processing timeout.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithAckTimeout(timeout time.Duration) *OptionsListener {
+	opts.ackTimeout = timeout
+
+	return opts
+}
+
+// WithHandlerTimeout makes ListenFunc cancel the context of the handler once it ran for timeout, unlike the
+// processing timeout of the channels whose consumer the Listener doesn't own. The canceled handlers are
+// counted by WithMetricHandlerTimeouts and reported to WithOnTimeout; if they return an error, the message
+// is processed as a timed out message, otherwise it's committed. The handler must return once its context
+// is done, as the Listener can't stop it otherwise.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithHandlerTimeout(timeout time.Duration) *OptionsListener {
+	opts.handlerTimeout = timeout
+
+	return opts
+}
+
+// WithMetricHandlerTimeouts sets the incrementer of the handlers whose context was canceled by WithHandlerTimeout.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricHandlerTimeouts(metric Incrementer) *OptionsListener {
+	opts.metricHandlerTimeouts = metric
+
+	return opts
+}
+
+// WithEnricher sets a function completing or transforming every message in place, e.g. with a reference
+// value looked up elsewhere, before Listen or ListenFunc delivers it, so the enrichment stays out of the
+// handler. The messages failing the enrichment are processed as dropped messages instead. The tombstones
+// are enriched too and the batches aren't, see WithBatchDelivery.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithEnricher(enricher Enricher) *OptionsListener {
+	opts.enricher = enricher
+
+	return opts
+}
+
+// WithMaxMessageSize sets the size of the biggest message value Listen and ListenFunc deliver, in bytes,
+// to protect the consumers with their own size limits. The oversized messages are handed to the dropped
+// message handler instead and committed, even if it fails, and counted by WithMetricMessagesOversized.
+// The batches aren't limited, see WithBatchDelivery.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMaxMessageSize(bytes int) *OptionsListener {
+	opts.maxMessageSize = bytes
+
+	return opts
+}
+
+// WithMaxMessageAge makes the listener skip the messages older than maxAge, by their time, instead of
+// delivering them, so a consumer recovering from a lag catches up by discarding the obsolete events.
+// The stale messages are counted by WithMetricMessagesStale, reported as OutcomeFiltered and their
+// offsets are committed along with the next processed message. The age is measured with the clock set
+// by WithClock.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMaxMessageAge(maxAge time.Duration) *OptionsListener {
+	opts.maxMessageAge = maxAge
+
+	return opts
+}
+
+// WithClock sets the function telling the current time, time.Now by default, which allows tests to
+// control the age of the messages, see WithMaxMessageAge and WithDeadlineFromMessageTime.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithClock(now func() time.Time) *OptionsListener {
+	opts.now = now
+
+	return opts
+}
+
+// WithDeadlineFromMessageTime makes the listener process every message within d of its time, i.e. of its
+// production, to meet an event-time latency SLA. A message already past its deadline is handed to the
+// dropped message handler, e.g. a dead-letter topic, instead of being delivered, and the processing
+// timeout of the others is the time left until their deadline, instead of WithProcessingTimeout. Like
+// a timed out message, a message past its deadline is committed once the dropped message handler
+// succeeds, see DropSemantics. The messages without time have no deadline. The time is told by the
+// clock set by WithClock.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithDeadlineFromMessageTime(d time.Duration) *OptionsListener {
+	opts.deadlineFromMessageTime = d
+
+	return opts
+}
+
+// WithReaderHealthProbe makes the listener swap the reader, like after a recoverable Kafka error, when its
+// fetches succeed but persistently take longer than maxFetchLatency while there are messages left to fetch,
+// which catches the connections silently degrading. The swaps are counted by WithMetricHealthProbeSwaps.
+// The readers unable to report their lag, unlike *kafka.Reader, are deemed lagging, so the threshold must
+// then exceed the usual wait for new messages. The fetches of WithPrefetch aren't probed.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithReaderHealthProbe(maxFetchLatency time.Duration) *OptionsListener {
+	opts.maxFetchLatency = maxFetchLatency
+
+	return opts
+}
+
+// WithRetryTopics makes a Nack, including an error sent on the error channel, returned by the handler of
+// ListenFunc or ProcessOnce, or given to the acknowledgement of Next, republish the message with
+// the given producer to the retry topic of the next delay instead of skipping it, so a failing message
+// doesn't block the partition. The message is then committed. The republished messages carry the number
+// of attempts and are held until their delay elapsed by the listeners consuming the retry topics. Once the
+// message went through every retry topic, it's handed to the dropped message handler, e.g. NewDLQHandler.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithRetryTopics(topics map[time.Duration]string, producer Writer) *OptionsListener {
+	opts.retryTiers = newRetryTiers(topics)
+	opts.retryWriter = producer
+
+	return opts
+}
+
+// WithRepublishHeaders sets the function returning the headers added to the messages republished to the
+// retry topics of WithRetryTopics and to the dead-letter topic of NewDLQHandler, e.g. tracing headers, so
+// every republishing path shares the same headers. They are merged with the headers of the original message,
+// which are never overwritten. The reason is the error the message was nacked or dropped with.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithRepublishHeaders(headers RepublishHeaders) *OptionsListener {
+	opts.republishHeaders = headers
+
+	return opts
+}
+
+// WithTimeoutHeader lets a message extend its processing timeout through the given header, whose
+// value is the timeout in milliseconds (e.g. "x-process-timeout-ms: 30000"), so known slow messages
+// aren't dropped without raising the timeout of every message. The extended timeout is capped by max.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithTimeoutHeader(header string, max time.Duration) *OptionsListener {
+	opts.timeoutHeader = header
+	opts.maxProcessingTimeout = max
+
+	return opts
+}
+
+// WithTopicProcessingTimeout sets the processing timeout of the messages of the given topics, so a
+// fast and a slow topic can be consumed by the same Listener. The other topics keep the processing
+// timeout set by WithProcessingTimeout. A batch gets the longest timeout of its messages.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithTopicProcessingTimeout(timeouts map[string]time.Duration) *OptionsListener {
+	opts.topicProcessingTimeout = timeouts
+
+	return opts
+}
+
+// WithOnTimeout sets a hook called when the processing of a message times out, so detailed
+// diagnostics (payload size, headers) can be captured for slow messages. The hook runs in the
+// processing loop, so it must be quick or fire its own goroutine.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOnTimeout(hook TimeoutHook) *OptionsListener {
+	opts.onTimeout = hook
+
+	return opts
+}
+
+// WithCommitter sets the Committer used to store the offsets of the processed messages instead of
+// committing them through the reader, e.g. to keep them in an external offset store.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithCommitter(committer Committer) *OptionsListener {
+	opts.committer = committer
+
+	return opts
+}
+
+// WithSecondaryCommitter sets a Committer the offsets are also committed to once the committer succeeded,
+// e.g. the offsets of a mirror cluster, so a failover resumes near the right position. It's best-effort:
+// its failures are logged and counted by WithMetricSecondaryCommitErrors, but they don't fail the commit.
+// The offsets are given as committed to the primary cluster, so the Committer must translate them to the
+// ones of the mirror, if needed. It's called synchronously, so it must honor its context.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithSecondaryCommitter(committer Committer) *OptionsListener {
+	opts.secondary = committer
+
+	return opts
+}
+
+// WithMetricSecondaryCommitErrors sets the incrementer of the failed commits of WithSecondaryCommitter.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricSecondaryCommitErrors(metric Incrementer) *OptionsListener {
+	opts.metricSecondaryErrors = metric
+
+	return opts
+}
+
+// WithOutcomeHook sets a hook called with every message once it's committed, dropped or filtered,
+// which gives a single record of the fate of every message, e.g. for auditing. The hook runs in
+// the processing loop and the commit loop, so it must not block.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOutcomeHook(hook OutcomeHook) *OptionsListener {
+	opts.onOutcome = hook
+
+	return opts
+}
+
+// WithOnMessage sets a passive observer called with every message right before its delivery, e.g. to
+// sample or trace the messages. The messages dropped before, e.g. by WithMaxMessageSize, aren't observed.
+// It can't affect whether the message is committed: it returns nothing and its panics are recovered and
+// logged. The hook runs in the processing loop, so it must not block, e.g. it should hand the message off
+// to a buffered channel rather than write it somewhere. With WithOrderedConcurrency, it's called by the
+// concurrent handlers, so it must be safe for concurrent use.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOnMessage(hook MessageHook) *OptionsListener {
+	opts.onMessage = hook
+
+	return opts
+}
+
+// WithOnPartitionsRevoked sets a hook called with the partitions revoked by a rebalance, once the
+// uncommitted messages were committed. The processing is paused while the hook runs.
+// The reader must implement GenerationReader, which *kafka.Reader doesn't, otherwise NewListener panics.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOnPartitionsRevoked(hook PartitionsHook) *OptionsListener {
+	opts.onPartitionsRevoked = hook
+
+	return opts
+}
+
+// WithOnPartitionsAssigned sets a hook called with the partitions assigned by a rebalance, before
+// processing their messages. The reader must implement GenerationReader, which *kafka.Reader doesn't,
+// otherwise NewListener panics.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithOnPartitionsAssigned(hook PartitionsHook) *OptionsListener {
+	opts.onPartitionsAssigned = hook
+
+	return opts
+}
+
 // WithReaderFactory sets the reader factory function for the Options instance.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithReaderFactory(readerFactory ReaderFactory) *OptionsListener {
@@ -99,6 +783,54 @@ func (opts *OptionsListener) WithReaderFactory(readerFactory ReaderFactory) *Opt
 	return opts
 }
 
+// WithConsumerGroupInstanceID asks for the static membership of the consumer group (KIP-345) with the given
+// stable id, so a member restarted within the session timeout gets its partitions back without a rebalance.
+// kafka-go doesn't support it: its consumer group joins without a group instance id and kafka.ReaderConfig
+// has no field to set it. Validate returns ErrStaticMembershipUnsupported instead of silently joining as a
+// dynamic member, so NewListener panics. Raise the SessionTimeout of the kafka.ReaderConfig above the
+// restart time of a member instead, which also delays the rebalance of a crashed member.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithConsumerGroupInstanceID(id string) *OptionsListener {
+	opts.groupInstanceID = id
+
+	return opts
+}
+
+// WithMetricMessagesFetched sets the messages fetched incrementer for the Options instance.
+// Every message fetched from Kafka is counted, even if it's skipped, so it's the denominator of the drop rate.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricMessagesFetched(metric Incrementer) *OptionsListener {
+	opts.metricMessagesFetched = metric
+
+	return opts
+}
+
+// WithMetricBytesConsumed sets the counter of the size, in bytes, of the values of the messages
+// delivered, to correlate the cost of the consumer with the volume of the payloads. Like WithOnMessage,
+// it doesn't count the messages dropped before their delivery.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricBytesConsumed(metric Adder) *OptionsListener {
+	opts.metricBytesConsumed = metric
+
+	return opts
+}
+
+// WithMetricMessagesOversized sets the incrementer of the messages dropped for exceeding WithMaxMessageSize.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricMessagesOversized(metric Incrementer) *OptionsListener {
+	opts.metricMessagesOversized = metric
+
+	return opts
+}
+
+// WithMetricMessagesStale sets the incrementer of the messages skipped for exceeding WithMaxMessageAge.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricMessagesStale(metric Incrementer) *OptionsListener {
+	opts.metricMessagesStale = metric
+
+	return opts
+}
+
 // WithMetricMessagesProcessed sets the messages processed incrementer for the Options instance.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithMetricMessagesProcessed(metric Incrementer) *OptionsListener {
@@ -108,6 +840,8 @@ func (opts *OptionsListener) WithMetricMessagesProcessed(metric Incrementer) *Op
 }
 
 // WithMetricMessagesDropped sets the messages dropped incrementer for the Options instance.
+// It only counts the dropped messages the dropped message handler failed to process, or didn't
+// process with DropRedeliver; the ones it processed are counted by WithMetricMessagesRescued.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithMetricMessagesDropped(metric Incrementer) *OptionsListener {
 	opts.metricMessagesDropped = metric
@@ -115,6 +849,25 @@ func (opts *OptionsListener) WithMetricMessagesDropped(metric Incrementer) *Opti
 	return opts
 }
 
+// WithMetricMessagesRescued sets the incrementer of the dropped messages the dropped message handler
+// processed successfully, e.g. sent to a dead-letter topic.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricMessagesRescued(metric Incrementer) *OptionsListener {
+	opts.metricMessagesRescued = metric
+
+	return opts
+}
+
+// WithMetricMessagesReleased sets the incrementer of the uncommitted messages discarded after a reconnection,
+// as the new generation doesn't assign their partitions anymore, so the new owner redelivers them.
+// The reader must implement GenerationReader, which *kafka.Reader doesn't, otherwise NewListener panics.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricMessagesReleased(metric Incrementer) *OptionsListener {
+	opts.metricMessagesReleased = metric
+
+	return opts
+}
+
 // WithMetricErrors sets the Kafka errors incrementer for the Options instance.
 // Returns the updated Options instance for method chaining.
 func (opts *OptionsListener) WithMetricErrors(metric Incrementer) *OptionsListener {
@@ -123,6 +876,93 @@ func (opts *OptionsListener) WithMetricErrors(metric Incrementer) *OptionsListen
 	return opts
 }
 
+// WithMetricLastCommittedTime sets the gauge for the Unix time, in seconds, of the last committed
+// message, so the lag can be monitored in wall-clock time instead of offsets.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricLastCommittedTime(metric Gauge) *OptionsListener {
+	opts.metricLastCommittedTime = metric
+
+	return opts
+}
+
+// WithMetricReconnects sets the metrics of the reconnections to Kafka: the histogram of their
+// duration in milliseconds and the counters of the successful and failed ones. A reconnection
+// fails when the old reader can't be closed or the reader factory returns nil.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricReconnects(duration Duration, successes, failures Incrementer) *OptionsListener {
+	opts.metricReconnectDuration = duration
+	opts.metricReconnects = successes
+	opts.metricReconnectFailures = failures
+
+	return opts
+}
+
+// WithMetricHealthProbeSwaps sets the incrementer of the readers swapped by WithReaderHealthProbe.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricHealthProbeSwaps(metric Incrementer) *OptionsListener {
+	opts.metricHealthProbeSwaps = metric
+
+	return opts
+}
+
+// WithMetricRebalances sets the incrementer of the rebalances, i.e. the changes of the generation of the
+// consumer group, to alert on the rebalance storms. The reader must implement GenerationReader, which
+// *kafka.Reader doesn't, otherwise NewListener panics.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricRebalances(metric Incrementer) *OptionsListener {
+	opts.metricRebalances = metric
+
+	return opts
+}
+
+// WithMetricFetchLatency sets the histogram for the time, in milliseconds, spent in the FetchMessage
+// calls of the reader, so the broker read latency can be told apart from the commit latency.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricFetchLatency(metric Duration) *OptionsListener {
+	opts.metricFetchLatency = metric
+
+	return opts
+}
+
+// WithMetricCommitLatency sets the histogram for the time, in milliseconds, spent in the CommitMessages
+// calls of the committer, see WithMetricFetchLatency.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricCommitLatency(metric Duration) *OptionsListener {
+	opts.metricCommitLatency = metric
+
+	return opts
+}
+
+// WithMetricUncommittedDepth sets the gauge for the highest number of messages left uncommitted since
+// the last commit, sampled by the commit loop before every commit attempt, so a stalled commit loop can be
+// told apart from a busy one. It requires the commit loop, see WithoutRecommitLoop.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricUncommittedDepth(metric Gauge) *OptionsListener {
+	opts.metricUncommittedDepth = metric
+
+	return opts
+}
+
+// WithUncommittedWarnThreshold makes the commit loop log a warning on every tick while more than n messages
+// were left uncommitted since the last commit, which surfaces a stalled commit loop before it becomes
+// a memory problem. See WithMetricUncommittedDepth.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithUncommittedWarnThreshold(n int) *OptionsListener {
+	opts.uncommittedWarnThreshold = n
+
+	return opts
+}
+
+// WithMetricAckLatency sets the histogram for the time, in milliseconds, from the delivery of
+// a message or batch to its acknowledgement. Unlike WithDurationProcess, it doesn't include the
+// wait for the consumer to receive the message, so its p99 is a good base for WithProcessingTimeout.
+// Returns the updated Options instance for method chaining.
+func (opts *OptionsListener) WithMetricAckLatency(metric Duration) *OptionsListener {
+	opts.metricAckLatency = metric
+
+	return opts
+}
+
 // NewOptionsListener creates a new Options instance with default values.
 func NewOptionsListener() *OptionsListener {
 	return &OptionsListener{}
@@ -132,43 +972,292 @@ func obtainFinalOptsListener(log Logger, opts []*OptionsListener) *OptionsListen
 	// Set the default options.
 	finalOpts := &OptionsListener{
 		recommitTicker:    time.NewTicker(commitInterval),
+		recommitInterval:  commitInterval,
 		processDroppedMsg: defaultProcessDroppedMsg,
 		processingTimeout: processingTimeout,
+		shutdownTimeout:   shutdownTimeout,
+		commitTimeout:     commitTimeout,
 		reconnectInterval: reconnectInterval,
+		batchMaxWait:      batchMaxWait,
+		throttleInterval:  throttleInterval,
+		throttleDelay:     throttleDelay,
+		onTimeout:         nopTimeoutHook,
+		onOutcome:         nopOutcomeHook,
+		onMessage:         nopMessageHook,
+		weight:            1,
+		republishHeaders:  nopRepublishHeaders,
+		now:               time.Now,
+
+		onPartitionsRevoked:  nopPartitionsHook,
+		onPartitionsAssigned: nopPartitionsHook,
+
 		readerFactory: func() Reader {
 			log.Panicf(ErrResourceIsNil, "provide the reader")
 
 			return nil
 		},
 
+		metricMessagesFetched:   new(nopIncrementer),
+		metricBytesConsumed:     new(nopAdder),
+		metricMessagesOversized: new(nopIncrementer),
+		metricMessagesStale:     new(nopIncrementer),
 		metricMessagesProcessed: new(nopIncrementer),
 		metricMessagesDropped:   new(nopIncrementer),
+		metricMessagesRescued:   new(nopIncrementer),
+		metricMessagesReleased:  new(nopIncrementer),
 		metricErrors:            new(nopIncrementer),
 		metricDurationProcess:   new(nopDuration),
+		metricLastCommittedTime: new(nopGauge),
+		metricReconnectDuration: new(nopDuration),
+		metricReconnects:        new(nopIncrementer),
+		metricReconnectFailures: new(nopIncrementer),
+		metricAckLatency:        new(nopDuration),
+		metricHealthProbeSwaps:  new(nopIncrementer),
+		metricRebalances:        new(nopIncrementer),
+		metricFetchLatency:      new(nopDuration),
+		metricCommitLatency:     new(nopDuration),
+		metricHandlerTimeouts:   new(nopIncrementer),
+		metricSecondaryErrors:   new(nopIncrementer),
+		metricUncommittedDepth:  new(nopGauge),
 	}
 
 	// Iterate through the provided custom options and override defaults if needed.
 	for _, opt := range opts {
+		if opt.name != "" {
+			finalOpts.name = opt.name
+		}
+
 		if opt.processingTimeout != 0 {
 			finalOpts.processingTimeout = opt.processingTimeout
 		}
 
+		if opt.commitTimeout != 0 {
+			finalOpts.commitTimeout = opt.commitTimeout
+		}
+
+		if opt.shutdownTimeout != 0 {
+			finalOpts.shutdownTimeout = opt.shutdownTimeout
+		}
+
 		if opt.reconnectInterval != 0 {
 			finalOpts.reconnectInterval = opt.reconnectInterval
 		}
 
+		if opt.initialBackoff != 0 {
+			finalOpts.initialBackoff = opt.initialBackoff
+		}
+
+		if opt.maxBackoff != 0 {
+			finalOpts.maxBackoff = opt.maxBackoff
+		}
+
+		if opt.batchMaxSize > 0 {
+			finalOpts.batchMaxSize = opt.batchMaxSize
+		}
+
+		if opt.batchMaxWait > 0 {
+			finalOpts.batchMaxWait = opt.batchMaxWait
+		}
+
+		if opt.batchMaxKeys > 0 {
+			finalOpts.batchMaxKeys = opt.batchMaxKeys
+		}
+
+		if opt.orderedConcurrency > 0 {
+			finalOpts.orderedConcurrency = opt.orderedConcurrency
+		}
+
+		if opt.keyAffinity {
+			finalOpts.keyAffinity = true
+		}
+
+		if opt.processingSlots > 0 {
+			finalOpts.processingSlots = opt.processingSlots
+		}
+
+		if opt.skipEmptyMessages {
+			finalOpts.skipEmptyMessages = true
+		}
+
+		if opt.checkpointOnAssign {
+			finalOpts.checkpointOnAssign = true
+		}
+
+		if opt.withoutRecommitLoop {
+			finalOpts.withoutRecommitLoop = true
+		}
+
+		if opt.commitOnShutdownOnly {
+			finalOpts.commitOnShutdownOnly = true
+		}
+
+		if opt.unbufferedAck {
+			finalOpts.unbufferedAck = true
+		}
+
+		if opt.commitInFlightOnShutdown {
+			finalOpts.commitInFlightOnShutdown = true
+		}
+
+		if opt.logCommittedOffsets {
+			finalOpts.logCommittedOffsets = true
+		}
+
+		if opt.routes != nil {
+			finalOpts.routingHeader = opt.routingHeader
+			finalOpts.routes = opt.routes
+		}
+
+		if opt.headersOnly {
+			finalOpts.headersOnly = true
+		}
+
+		if opt.tombstoneHandler != nil {
+			finalOpts.tombstoneHandler = opt.tombstoneHandler
+		}
+
+		if opt.enricher != nil {
+			finalOpts.enricher = opt.enricher
+		}
+
+		if opt.maxMessageSize != 0 {
+			finalOpts.maxMessageSize = opt.maxMessageSize
+		}
+
+		if opt.maxMessageAge != 0 {
+			finalOpts.maxMessageAge = opt.maxMessageAge
+		}
+
+		if opt.now != nil {
+			finalOpts.now = opt.now
+		}
+
+		if opt.deadlineFromMessageTime != 0 {
+			finalOpts.deadlineFromMessageTime = opt.deadlineFromMessageTime
+		}
+
+		if opt.timeoutHeader != "" {
+			finalOpts.timeoutHeader = opt.timeoutHeader
+			finalOpts.maxProcessingTimeout = opt.maxProcessingTimeout
+		}
+
+		if opt.topicProcessingTimeout != nil {
+			finalOpts.topicProcessingTimeout = opt.topicProcessingTimeout
+		}
+
+		if opt.deliveryTimeout != 0 {
+			finalOpts.deliveryTimeout = opt.deliveryTimeout
+		}
+
+		if opt.ackTimeout != 0 {
+			finalOpts.ackTimeout = opt.ackTimeout
+		}
+
+		if opt.handlerTimeout != 0 {
+			finalOpts.handlerTimeout = opt.handlerTimeout
+		}
+
+		if opt.onTimeout != nil {
+			finalOpts.onTimeout = opt.onTimeout
+		}
+
+		if opt.onOutcome != nil {
+			finalOpts.onOutcome = opt.onOutcome
+		}
+
+		if opt.onMessage != nil {
+			finalOpts.onMessage = opt.onMessage
+		}
+
+		if opt.onPartitionsRevoked != nil {
+			finalOpts.onPartitionsRevoked = opt.onPartitionsRevoked
+		}
+
+		if opt.onPartitionsAssigned != nil {
+			finalOpts.onPartitionsAssigned = opt.onPartitionsAssigned
+		}
+
 		if opt.recommitTicker != nil {
 			finalOpts.recommitTicker = opt.recommitTicker
+			finalOpts.recommitInterval = opt.recommitInterval
+		}
+
+		if opt.recommitJitter > 0 {
+			finalOpts.recommitJitter = math.Min(opt.recommitJitter, maxRecommitJitter)
 		}
 
 		if opt.processDroppedMsg != nil {
 			finalOpts.processDroppedMsg = opt.processDroppedMsg
 		}
 
+		if opt.expectedCompression != 0 {
+			finalOpts.expectedCompression = opt.expectedCompression
+		}
+
+		if opt.memThreshold > 0 {
+			finalOpts.memThreshold = opt.memThreshold
+		}
+
+		if opt.throttleInterval > 0 {
+			finalOpts.throttleInterval = opt.throttleInterval
+		}
+
+		if opt.throttleDelay > 0 {
+			finalOpts.throttleDelay = opt.throttleDelay
+		}
+
+		if opt.prefetch > 0 {
+			finalOpts.prefetch = opt.prefetch
+		}
+
+		if opt.dropSemantics != 0 {
+			finalOpts.dropSemantics = opt.dropSemantics
+		}
+
+		if opt.weight > 0 {
+			finalOpts.weight = opt.weight
+		}
+
+		if opt.commitMode != 0 {
+			finalOpts.commitMode = opt.commitMode
+		}
+
 		if opt.readerFactory != nil {
 			finalOpts.readerFactory = opt.readerFactory
 		}
 
+		if opt.groupInstanceID != "" {
+			finalOpts.groupInstanceID = opt.groupInstanceID
+		}
+
+		if opt.committer != nil {
+			finalOpts.committer = opt.committer
+		}
+
+		if opt.secondary != nil {
+			finalOpts.secondary = opt.secondary
+		}
+
+		if opt.metricSecondaryErrors != nil {
+			finalOpts.metricSecondaryErrors = opt.metricSecondaryErrors
+		}
+
+		if opt.metricMessagesFetched != nil {
+			finalOpts.metricMessagesFetched = opt.metricMessagesFetched
+		}
+
+		if opt.metricBytesConsumed != nil {
+			finalOpts.metricBytesConsumed = opt.metricBytesConsumed
+		}
+
+		if opt.metricMessagesOversized != nil {
+			finalOpts.metricMessagesOversized = opt.metricMessagesOversized
+		}
+
+		if opt.metricMessagesStale != nil {
+			finalOpts.metricMessagesStale = opt.metricMessagesStale
+		}
+
 		if opt.metricMessagesProcessed != nil {
 			finalOpts.metricMessagesProcessed = opt.metricMessagesProcessed
 		}
@@ -177,6 +1266,14 @@ func obtainFinalOptsListener(log Logger, opts []*OptionsListener) *OptionsListen
 			finalOpts.metricMessagesDropped = opt.metricMessagesDropped
 		}
 
+		if opt.metricMessagesRescued != nil {
+			finalOpts.metricMessagesRescued = opt.metricMessagesRescued
+		}
+
+		if opt.metricMessagesReleased != nil {
+			finalOpts.metricMessagesReleased = opt.metricMessagesReleased
+		}
+
 		if opt.metricErrors != nil {
 			finalOpts.metricErrors = opt.metricErrors
 		}
@@ -184,6 +1281,67 @@ func obtainFinalOptsListener(log Logger, opts []*OptionsListener) *OptionsListen
 		if opt.metricDurationProcess != nil {
 			finalOpts.metricDurationProcess = opt.metricDurationProcess
 		}
+
+		if opt.metricLastCommittedTime != nil {
+			finalOpts.metricLastCommittedTime = opt.metricLastCommittedTime
+		}
+
+		if opt.metricReconnectDuration != nil {
+			finalOpts.metricReconnectDuration = opt.metricReconnectDuration
+		}
+
+		if opt.metricReconnects != nil {
+			finalOpts.metricReconnects = opt.metricReconnects
+		}
+
+		if opt.metricReconnectFailures != nil {
+			finalOpts.metricReconnectFailures = opt.metricReconnectFailures
+		}
+
+		if opt.metricAckLatency != nil {
+			finalOpts.metricAckLatency = opt.metricAckLatency
+		}
+
+		if opt.metricHealthProbeSwaps != nil {
+			finalOpts.metricHealthProbeSwaps = opt.metricHealthProbeSwaps
+		}
+
+		if opt.metricRebalances != nil {
+			finalOpts.metricRebalances = opt.metricRebalances
+		}
+
+		if opt.metricFetchLatency != nil {
+			finalOpts.metricFetchLatency = opt.metricFetchLatency
+		}
+
+		if opt.metricCommitLatency != nil {
+			finalOpts.metricCommitLatency = opt.metricCommitLatency
+		}
+
+		if opt.metricHandlerTimeouts != nil {
+			finalOpts.metricHandlerTimeouts = opt.metricHandlerTimeouts
+		}
+
+		if opt.metricUncommittedDepth != nil {
+			finalOpts.metricUncommittedDepth = opt.metricUncommittedDepth
+		}
+
+		if opt.uncommittedWarnThreshold != 0 {
+			finalOpts.uncommittedWarnThreshold = opt.uncommittedWarnThreshold
+		}
+
+		if opt.maxFetchLatency != 0 {
+			finalOpts.maxFetchLatency = opt.maxFetchLatency
+		}
+
+		if opt.retryTiers != nil {
+			finalOpts.retryTiers = opt.retryTiers
+			finalOpts.retryWriter = opt.retryWriter
+		}
+
+		if opt.republishHeaders != nil {
+			finalOpts.republishHeaders = opt.republishHeaders
+		}
 	}
 
 	return finalOpts
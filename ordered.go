@@ -0,0 +1,190 @@
+package kafko
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// sequencer tracks the messages processed concurrently so they are committed in fetch order.
+// Every fetched message gets a sequence number and the watermark only advances over the
+// contiguous sequence numbers whose processing completed.
+type sequencer struct {
+	mutex     sync.Locker
+	next      uint64 // Sequence number of the next fetched message.
+	watermark uint64 // Sequence number of the oldest message whose processing didn't complete.
+	completed map[uint64]*kafka.Message
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		mutex:     &sync.Mutex{},
+		completed: map[uint64]*kafka.Message{},
+	}
+}
+
+// add returns the sequence number of a fetched message.
+func (seq *sequencer) add() uint64 {
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+
+	number := seq.next
+	seq.next++
+
+	return number
+}
+
+// complete marks the processing of the message with the given sequence number as completed, message
+// being nil if it mustn't be committed, and returns the messages to commit after advancing the watermark.
+func (seq *sequencer) complete(number uint64, message *kafka.Message) []kafka.Message {
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+
+	seq.completed[number] = message
+
+	ready := []kafka.Message{}
+
+	for {
+		message, ok := seq.completed[seq.watermark]
+		if !ok {
+			return ready
+		}
+
+		if message != nil {
+			ready = append(ready, *message)
+		}
+
+		delete(seq.completed, seq.watermark)
+		seq.watermark++
+	}
+}
+
+// keyLanes chains the messages by the hash of their key so the messages of a lane are processed in
+// fetch order, see WithKeyAffinity. It's only used by the fetching goroutine.
+type keyLanes struct {
+	last []chan struct{} // Closed once the last message of every lane was processed.
+}
+
+func newKeyLanes(size int) *keyLanes {
+	return &keyLanes{last: make([]chan struct{}, size)}
+}
+
+// chain returns the channel closed once the previous message of the lane of the key was processed,
+// nil if there's none to wait for, and the channel to close once this message is processed, nil if
+// the message has no key.
+func (lanes *keyLanes) chain(key []byte) (previous, done chan struct{}) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	lane := hash.Sum32() % uint32(len(lanes.last))
+
+	previous, done = lanes.last[lane], make(chan struct{})
+	lanes.last[lane] = done
+
+	return previous, done
+}
+
+// orderedTick returns a processTick that hands every fetched message to the handler in its own
+// goroutine, at most slots at a time, and commits them in fetch order, see WithOrderedConcurrency.
+func (listener *Listener) orderedTick(handler Handler, slots int) func(ctx context.Context) error {
+	seq := newSequencer()
+	busy := make(chan struct{}, slots)
+	lanes := newKeyLanes(slots)
+
+	return func(ctx context.Context) error {
+		// Wait for a free slot before fetching, so no fetched message waits for a worker.
+		select {
+		case busy <- struct{}{}:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (orderedTick)")
+		}
+
+		// Also wait for a slot of WithProcessingSemaphore, released once the message is committed.
+		if err := listener.acquireProcessingSlot(ctx); err != nil {
+			<-busy
+
+			return err
+		}
+
+		delivered := false
+
+		err := listener.processTick(ctx, func(ctx context.Context, message kafka.Message) error {
+			delivered = true
+			number := seq.add()
+
+			// Chain the message after the previous one of its lane, see WithKeyAffinity.
+			var previous, done chan struct{}
+			if listener.opts.keyAffinity {
+				previous, done = lanes.chain(message.Key)
+			}
+
+			listener.workersWaitGroup.Add(1)
+
+			listener.spawn(func() {
+				defer listener.workersWaitGroup.Done()
+				defer func() { <-busy }()
+				defer listener.releaseProcessingSlot()
+
+				if done != nil {
+					defer close(done)
+				}
+
+				if previous != nil {
+					<-previous
+				}
+
+				listener.processOrdered(ctx, seq, number, message, handler)
+			})
+
+			return nil
+		})
+
+		if !delivered {
+			listener.releaseProcessingSlot()
+			<-busy
+		}
+
+		return err
+	}
+}
+
+// processOrdered calls the handler with the message and commits the messages whose turn came.
+// Like runCommitLoop, commit errors are only logged because the messages stay uncommitted.
+func (listener *Listener) processOrdered(ctx context.Context, seq *sequencer, number uint64, message kafka.Message, handler Handler) {
+	start := time.Now()
+	processed := &message
+
+	if err := handler(ctx, message); err != nil {
+		listener.log.Errorf(err, "Failed to process message =%v", message)
+
+		// Unless it was retried or the dropped message handler took it over, the message is skipped.
+		if len(listener.failMessages(ctx, []kafka.Message{message}, err)) == 0 {
+			processed = nil
+		}
+	} else {
+		duration := time.Since(start)
+		listener.opts.metricDurationProcess.Observe(float64(duration.Milliseconds()))
+	}
+
+	ready := seq.complete(number, processed)
+	if len(ready) == 0 || listener.committedBeforeProcess() {
+		return
+	}
+
+	listener.addUncommittedMsg(ready...)
+
+	if listener.opts.commitOnShutdownOnly {
+		return
+	}
+
+	if err := listener.commitUncommittedMessages(ctx); err != nil {
+		listener.log.Errorf(err, "err := queue.commitUncommittedMessages(ctx)")
+	}
+}
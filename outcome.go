@@ -0,0 +1,44 @@
+package kafko
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// Outcome is the fate of a message, see WithOutcomeHook.
+type Outcome int
+
+const (
+	// OutcomeCommitted means the message was processed and committed.
+	OutcomeCommitted Outcome = iota
+	// OutcomeDropped means the message was handed to the dropped message handler.
+	OutcomeDropped
+	// OutcomeFiltered means the message was skipped without being processed.
+	OutcomeFiltered
+)
+
+// String returns the name of the Outcome.
+func (outcome Outcome) String() string {
+	switch outcome {
+	case OutcomeCommitted:
+		return "committed"
+	case OutcomeDropped:
+		return "dropped"
+	case OutcomeFiltered:
+		return "filtered"
+	}
+
+	return "unknown"
+}
+
+// OutcomeHook is called with every message and its fate. The committed messages have no value,
+// as it isn't kept until the commit, but their key and headers are set.
+type OutcomeHook func(message kafka.Message, outcome Outcome)
+
+func nopOutcomeHook(kafka.Message, Outcome) {}
+
+// reportOutcome calls the outcome hook with every given message.
+func (listener *Listener) reportOutcome(outcome Outcome, messages ...kafka.Message) {
+	for _, message := range messages {
+		listener.opts.onOutcome(message, outcome)
+	}
+}
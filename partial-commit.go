@@ -0,0 +1,146 @@
+package kafko
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// TopicPartition identifies a partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int
+}
+
+// PartialCommitError reports the partitions whose messages were and weren't committed,
+// keyed by partition with the highest offset of their messages.
+type PartialCommitError struct {
+	Committed   map[TopicPartition]int64
+	Uncommitted map[TopicPartition]int64
+	Err         error
+}
+
+func (partial *PartialCommitError) Error() string {
+	return fmt.Sprintf("partial commit (committed = %v, uncommitted = %v): %v", partial.Committed, partial.Uncommitted, partial.Err)
+}
+
+func (partial *PartialCommitError) Unwrap() error {
+	return partial.Err
+}
+
+// groupByPartition groups the messages by partition, keeping their order, and returns the
+// partitions sorted by topic and partition.
+func groupByPartition(messages []kafka.Message) ([]TopicPartition, map[TopicPartition][]kafka.Message) {
+	groups := map[TopicPartition][]kafka.Message{}
+	partitions := []TopicPartition{}
+
+	for _, message := range messages {
+		partition := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+		if _, ok := groups[partition]; !ok {
+			partitions = append(partitions, partition)
+		}
+
+		groups[partition] = append(groups[partition], message)
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+
+		return partitions[i].Partition < partitions[j].Partition
+	})
+
+	return partitions, groups
+}
+
+// highestOffset returns the highest offset of the given messages.
+func highestOffset(messages []kafka.Message) int64 {
+	offset := messages[0].Offset
+
+	for _, message := range messages[1:] {
+		if message.Offset > offset {
+			offset = message.Offset
+		}
+	}
+
+	return offset
+}
+
+// offsetsByPartition returns the highest offset of the given messages by partition.
+func offsetsByPartition(messages []kafka.Message) map[TopicPartition]int64 {
+	partitions, groups := groupByPartition(messages)
+	offsets := make(map[TopicPartition]int64, len(partitions))
+
+	for _, partition := range partitions {
+		offsets[partition] = highestOffset(groups[partition])
+	}
+
+	return offsets
+}
+
+// commitUncommittedByPartition commits the uncommitted messages one partition at a time, so a slow
+// broker or an expiring ctx only costs the progress of the partitions that couldn't be committed.
+// The messages of those partitions are kept as uncommitted and reported by a PartialCommitError.
+// It returns the highest committed offset of every partition.
+func (listener *Listener) commitUncommittedByPartition(ctx context.Context) (map[TopicPartition]int64, error) {
+	// Lock the mutex to avoid concurrent access to uncommitted messages.
+	listener.uncommittedMsgsMutex.Lock()
+	defer listener.uncommittedMsgsMutex.Unlock()
+
+	if len(listener.uncommittedMsgs) == 0 {
+		return map[TopicPartition]int64{}, nil
+	}
+
+	return listener.commitByPartition(ctx, uncommittedMessages(listener.uncommittedMsgs))
+}
+
+// commitByPartition commits the given messages one partition at a time and keeps as uncommitted only
+// the messages of the partitions that couldn't be committed, reported by a PartialCommitError.
+// It returns the highest committed offset of every partition. It must be called with the
+// uncommittedMsgsMutex locked.
+func (listener *Listener) commitByPartition(ctx context.Context, messages []kafka.Message) (map[TopicPartition]int64, error) {
+	partitions, groups := groupByPartition(messages)
+	partial := &PartialCommitError{
+		Committed:   map[TopicPartition]int64{},
+		Uncommitted: map[TopicPartition]int64{},
+	}
+	remaining := []uncommittedOffset{}
+
+	for _, partition := range partitions {
+		messages := groups[partition]
+
+		// Stop committing once the ctx expired, there's no time left.
+		err := ctx.Err()
+		if err == nil {
+			err = listener.commitMessages(ctx, messages)
+		}
+
+		if err != nil {
+			listener.opts.metricErrors.Inc()
+
+			partial.Uncommitted[partition] = highestOffset(messages)
+			partial.Err = err
+			remaining = append(remaining, newUncommittedOffsets(messages)...)
+
+			continue
+		}
+
+		listener.opts.metricMessagesProcessed.Inc()
+		listener.trackCommitted(messages)
+		listener.reportOutcome(OutcomeCommitted, messages...)
+
+		partial.Committed[partition] = highestOffset(messages)
+	}
+
+	listener.uncommittedMsgs = remaining
+	listener.peakUncommitted = len(remaining)
+
+	if len(partial.Uncommitted) > 0 {
+		return partial.Committed, partial
+	}
+
+	return partial.Committed, nil
+}
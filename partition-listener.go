@@ -0,0 +1,71 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// OffsetStore keeps the offsets of a PartitionListener outside Kafka, usually in the database
+// the messages are processed into. Save is called once the message is processed, so on its own the
+// store is at-least-once, see NewPartitionListener.
+type OffsetStore interface {
+	// Load returns the offset to resume the partition from, or kafka.FirstOffset if none was saved.
+	Load(ctx context.Context, partition TopicPartition) (int64, error)
+	// Save stores the offset to resume the partition from, i.e. the last processed offset plus one.
+	Save(ctx context.Context, partition TopicPartition, offset int64) error
+}
+
+// storeCommitter returns a Committer saving the offsets of the committed messages into the store.
+func storeCommitter(store OffsetStore) Committer {
+	return CommitFunc(func(ctx context.Context, msgs ...kafka.Message) error {
+		partitions, groups := groupByPartition(msgs)
+
+		for _, partition := range partitions {
+			if err := store.Save(ctx, partition, highestOffset(groups[partition])+1); err != nil {
+				return errors.Wrapf(err, "err := store.Save(ctx, partition, offset) (partition = %v)", partition)
+			}
+		}
+
+		return nil
+	})
+}
+
+// NewPartitionListener creates a Listener reading a single partition, config.Topic and
+// config.Partition, without a consumer group. The offsets are kept in the given store instead
+// of being committed to Kafka: the reader resumes from the stored offset every time it's
+// (re)created and every processed message saves its offset.
+//
+// The offsets are saved after the processing, by the commit of the message, not along with the
+// processed data: a crash in between redelivers the message. For exactly-once processing, the handler
+// writes message.Offset+1 to the store in the transaction of the processed data itself, and Save
+// ignores an offset lower than the stored one, as a retried commit may save an older one.
+//
+// Use NewListener, with a consumer group, to share the partitions of a topic among several
+// instances. Use NewPartitionListener when the instance owns its partitions and the offsets
+// must be stored along with the processed data.
+func NewPartitionListener(log Logger, config kafka.ReaderConfig, store OffsetStore, opts ...*OptionsListener) *Listener {
+	partition := TopicPartition{Topic: config.Topic, Partition: config.Partition}
+
+	partitionOpts := NewOptionsListener().
+		WithCommitter(storeCommitter(store)).
+		WithReaderFactory(func() Reader {
+			ctx, cancel := context.WithTimeout(context.Background(), dialerTimeout)
+			defer cancel()
+
+			offset, err := store.Load(ctx, partition)
+			if err != nil {
+				log.Panicf(err, "offset, err := store.Load(ctx, partition) (partition = %v)", partition)
+			}
+
+			reader := kafka.NewReader(config)
+			if err := reader.SetOffset(offset); err != nil {
+				log.Panicf(err, "err := reader.SetOffset(offset) (offset = %d)", offset)
+			}
+
+			return reader
+		})
+
+	return NewListener(log, append([]*OptionsListener{partitionOpts}, opts...)...)
+}
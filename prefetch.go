@@ -0,0 +1,127 @@
+package kafko
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// prefetchResult is a message, or the error, fetched ahead by runPrefetch.
+type prefetchResult struct {
+	message kafka.Message
+	err     error
+}
+
+// startPrefetch starts fetching messages ahead of the processing into a buffer of the given size,
+// see WithPrefetch. The fetching stops once ctx is done or the shutdown starts and running, the
+// goroutines of the current Listen, waits for it. It isn't tracked by workersWaitGroup, as it only
+// stops with the Listen, so Drain would never see the Listener idle.
+func (listener *Listener) startPrefetch(ctx context.Context, size int, running *sync.WaitGroup) {
+	listener.prefetched = make(chan prefetchResult, size)
+	listener.prefetchResume = make(chan struct{}, 1)
+	listener.prefetchPaused = false
+
+	running.Add(1)
+
+	listener.spawn(func() {
+		defer running.Done()
+
+		listener.runPrefetch(ctx, listener.prefetched, listener.prefetchResume)
+	})
+}
+
+// runPrefetch fetches messages into prefetched. After an error, it waits for the processing to handle
+// it, as handling it may replace the reader, before fetching again.
+func (listener *Listener) runPrefetch(ctx context.Context, prefetched chan<- prefetchResult, resume <-chan struct{}) {
+	for {
+		reader := listener.currentReader()
+		message, err := listener.readMessage(ctx, reader)
+
+		// If the reader was replaced meanwhile, e.g. after a failed commit, fetch from the new one.
+		if err != nil && ctx.Err() == nil && reader != listener.currentReader() {
+			continue
+		}
+
+		select {
+		case prefetched <- prefetchResult{message: message, err: err}:
+		case <-ctx.Done():
+			return
+		case <-listener.shuttingDownCh:
+			return
+		}
+
+		if err == nil {
+			continue
+		}
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return
+		case <-listener.shuttingDownCh:
+			return
+		}
+	}
+}
+
+// fetchMessage fetches the next message from the prefetch buffer if WithPrefetch is set,
+// otherwise from the reader. It must be called with the processing lock held.
+func (listener *Listener) fetchMessage(ctx context.Context) (kafka.Message, error) {
+	if listener.prefetched == nil {
+		return listener.readMessage(ctx, listener.reader)
+	}
+
+	select {
+	case result := <-listener.prefetched:
+		listener.prefetchPaused = result.err != nil
+
+		return result.message, result.err
+
+	case <-ctx.Done():
+		return kafka.Message{}, errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (fetchMessage)")
+	}
+}
+
+// readMessage fetches the next message from the given reader and observes the time it took, see WithMetricFetchLatency.
+func (listener *Listener) readMessage(ctx context.Context, reader Reader) (kafka.Message, error) {
+	if err := listener.throttle(ctx); err != nil {
+		return kafka.Message{}, err
+	}
+
+	start := time.Now()
+	message, err := reader.FetchMessage(ctx)
+
+	latency := time.Since(start)
+	listener.opts.metricFetchLatency.Observe(float64(latency.Milliseconds()))
+
+	return message, err //nolint:wrapcheck // The error is handled by handleKafkaError.
+}
+
+// resumePrefetch lets the prefetching go on once the error it fetched was handled.
+// It must be called with the processing lock held.
+func (listener *Listener) resumePrefetch() {
+	if !listener.prefetchPaused {
+		return
+	}
+
+	listener.prefetchPaused = false
+	listener.prefetchResume <- struct{}{}
+}
+
+// currentReader returns the reader, which may be replaced concurrently by reconnectToKafka.
+func (listener *Listener) currentReader() Reader {
+	reader, _ := listener.currentReaderState()
+
+	return reader
+}
+
+// currentReaderState returns the reader like currentReader along with whether it's closed, see closeReader.
+func (listener *Listener) currentReaderState() (Reader, bool) {
+	listener.readerMutex.RLock()
+	defer listener.readerMutex.RUnlock()
+
+	return listener.reader, listener.readerClosed
+}
@@ -61,8 +61,8 @@ func (publisher *Publisher) writeMessages(ctx context.Context, bytes []byte) err
 
 		publisher.opts.metricErrors.Inc()
 
-		if err := publisher.opts.processDroppedMsg(&message, publisher.log); err != nil {
-			publisher.log.Errorf(err, "err := queue.opts.processDroppedMsg(&message, queue.log)")
+		if err := publisher.opts.processDroppedMsg(ctx, &message, publisher.log); err != nil {
+			publisher.log.Errorf(err, "err := queue.opts.processDroppedMsg(ctx, &message, queue.log)")
 		}
 
 		if atomic.CompareAndSwapInt32(&publisher.alreadyRewrote, 0, 1) {
@@ -122,7 +122,7 @@ func TestPublisher(t *testing.T) { //nolint:funlen
 
 		opts := kafko.NewOptionsPublisher().
 			WithWriterFactory(writerFactory).
-			WithProcessDroppedMsg(processDroppedMsg)
+			WithProcessDroppedMsg(kafko.LegacyProcessDroppedMsg(processDroppedMsg))
 		publisher := kafko.NewPublisher(mockLogger, opts)
 
 		payload := struct { //nolint:musttag
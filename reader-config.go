@@ -0,0 +1,115 @@
+package kafko
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultMaxBytes = 4 << 20
+	defaultMinBytes = 1
+	defaultMaxWait  = time.Duration(500) * time.Millisecond
+)
+
+// ReaderTuning holds the settings trading the latency of the reader for its throughput.
+type ReaderTuning struct {
+	// MaxBytes is the maximum size of a fetch response. It must fit the biggest message of the topic.
+	MaxBytes int
+	// MinBytes is the size the broker waits to accumulate before answering a fetch, for up to MaxWait.
+	// Raising it improves the throughput of busy topics, at the cost of a latency up to MaxWait.
+	MinBytes int
+	// MaxWait is the maximum time the broker waits for MinBytes before answering a fetch.
+	MaxWait time.Duration
+}
+
+// DefaultReaderTuning returns the tuning favoring latency that the ReaderConfigBuilder applies by
+// default to the zero fields of a tuning: 4 MiB of MaxBytes, 1 byte of MinBytes and 500ms of MaxWait.
+func DefaultReaderTuning() ReaderTuning {
+	return ReaderTuning{
+		MaxBytes: defaultMaxBytes,
+		MinBytes: defaultMinBytes,
+		MaxWait:  defaultMaxWait,
+	}
+}
+
+// ReaderConfigBuilder builds a kafka.ReaderConfig with the settings Kafko users commonly need,
+// so they don't have to be remembered in every reader factory.
+type ReaderConfigBuilder struct {
+	config kafka.ReaderConfig
+}
+
+// NewReaderConfig creates a ReaderConfigBuilder starting from the given configuration,
+// which usually sets the brokers, the topic, the group and the dialer.
+func NewReaderConfig(base kafka.ReaderConfig) *ReaderConfigBuilder {
+	return &ReaderConfigBuilder{config: base}
+}
+
+// WithReadCommitted makes the reader skip the messages of aborted transactions, which is
+// required to consume the topics written by transactional producers.
+// Returns the updated builder for method chaining.
+func (builder *ReaderConfigBuilder) WithReadCommitted() *ReaderConfigBuilder {
+	builder.config.IsolationLevel = kafka.ReadCommitted
+
+	return builder
+}
+
+// WithTuning sets the MaxBytes, MinBytes and MaxWait of the reader. The zero fields of the
+// tuning take the value of DefaultReaderTuning.
+// Returns the updated builder for method chaining.
+func (builder *ReaderConfigBuilder) WithTuning(tuning ReaderTuning) *ReaderConfigBuilder {
+	defaults := DefaultReaderTuning()
+
+	if tuning.MaxBytes == 0 {
+		tuning.MaxBytes = defaults.MaxBytes
+	}
+
+	if tuning.MinBytes == 0 {
+		tuning.MinBytes = defaults.MinBytes
+	}
+
+	if tuning.MaxWait == 0 {
+		tuning.MaxWait = defaults.MaxWait
+	}
+
+	builder.config.MaxBytes = tuning.MaxBytes
+	builder.config.MinBytes = tuning.MinBytes
+	builder.config.MaxWait = tuning.MaxWait
+
+	return builder
+}
+
+// WithGroupBalancers sets the strategies assigning the partitions to the members of the consumer
+// group, in order of preference, e.g. kafka.RangeGroupBalancer{} to assign the same partitions of
+// co-partitioned topics to the same member, as joins require.
+// Returns the updated builder for method chaining.
+func (builder *ReaderConfigBuilder) WithGroupBalancers(balancers ...kafka.GroupBalancer) *ReaderConfigBuilder {
+	builder.config.GroupBalancers = balancers
+
+	return builder
+}
+
+// WithStartOffset sets where the consumer group starts reading a partition without a committed offset,
+// kafka.FirstOffset, the default, to process the whole history, or kafka.LastOffset to only process the
+// messages produced from now on. It only applies to the brand-new groups, or to the partitions whose
+// offsets expired: once the group committed an offset, the reader resumes from it.
+// Returns the updated builder for method chaining.
+func (builder *ReaderConfigBuilder) WithStartOffset(offset int64) *ReaderConfigBuilder {
+	builder.config.StartOffset = offset
+
+	return builder
+}
+
+// WithDialer sets the dialer of the given SharedDialer, so the reader, and the ones of its
+// reconnections, share the authentication of the other readers of the process.
+// Returns the updated builder for method chaining.
+func (builder *ReaderConfigBuilder) WithDialer(shared *SharedDialer) *ReaderConfigBuilder {
+	builder.config.Dialer = shared.Dialer()
+
+	return builder
+}
+
+// Build returns the resulting kafka.ReaderConfig.
+func (builder *ReaderConfigBuilder) Build() kafka.ReaderConfig {
+	return builder.config
+}
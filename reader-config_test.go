@@ -0,0 +1,92 @@
+package kafko_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m3co/kafko"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReaderConfig checks that the builder keeps the base configuration and applies the options.
+func TestReaderConfig(t *testing.T) {
+	t.Parallel()
+
+	base := kafka.ReaderConfig{
+		GroupID: "group",
+		Topic:   "topic",
+		Brokers: []string{"broker:9092"},
+	}
+
+	assert.Equal(t, base, kafko.NewReaderConfig(base).Build())
+
+	config := kafko.NewReaderConfig(base).WithReadCommitted().Build()
+
+	assert.Equal(t, kafka.ReadCommitted, config.IsolationLevel)
+	assert.Equal(t, base.GroupID, config.GroupID)
+	assert.Equal(t, base.Topic, config.Topic)
+	assert.Equal(t, base.Brokers, config.Brokers)
+}
+
+// TestReaderTuning checks that the tuning is applied and that its zero fields take the defaults.
+func TestReaderTuning(t *testing.T) {
+	t.Parallel()
+
+	config := kafko.NewReaderConfig(kafka.ReaderConfig{}).WithTuning(kafko.DefaultReaderTuning()).Build()
+
+	assert.Equal(t, 4<<20, config.MaxBytes)
+	assert.Equal(t, 1, config.MinBytes)
+	assert.Equal(t, 500*time.Millisecond, config.MaxWait)
+
+	config = kafko.NewReaderConfig(kafka.ReaderConfig{}).WithTuning(kafko.ReaderTuning{
+		MinBytes: 1 << 10,
+		MaxWait:  2 * time.Second,
+	}).Build()
+
+	assert.Equal(t, 4<<20, config.MaxBytes)
+	assert.Equal(t, 1<<10, config.MinBytes)
+	assert.Equal(t, 2*time.Second, config.MaxWait)
+}
+
+// TestReaderGroupBalancers checks that the group balancers are wired in order of preference.
+func TestReaderGroupBalancers(t *testing.T) {
+	t.Parallel()
+
+	config := kafko.NewReaderConfig(kafka.ReaderConfig{GroupID: "group"}).
+		WithGroupBalancers(kafka.RangeGroupBalancer{}, kafka.RoundRobinGroupBalancer{}).
+		Build()
+
+	assert.Equal(t, []kafka.GroupBalancer{kafka.RangeGroupBalancer{}, kafka.RoundRobinGroupBalancer{}}, config.GroupBalancers)
+}
+
+// TestReaderStartOffset checks that the start offset of the consumer group is set.
+func TestReaderStartOffset(t *testing.T) {
+	t.Parallel()
+
+	config := kafko.NewReaderConfig(kafka.ReaderConfig{GroupID: "group"}).WithStartOffset(kafka.LastOffset).Build()
+
+	assert.Equal(t, kafka.LastOffset, config.StartOffset)
+	assert.Equal(t, "group", config.GroupID)
+}
+
+// TestSharedDialer checks that the readers built with a SharedDialer share its dialer, and its TLS
+// sessions, and that its transport authenticates like it.
+func TestSharedDialer(t *testing.T) {
+	t.Parallel()
+
+	dialer := kafko.NewDialer("username", "password")
+	shared := kafko.NewSharedDialer(dialer)
+
+	defer shared.Close()
+
+	first := kafko.NewReaderConfig(kafka.ReaderConfig{Topic: "orders"}).WithDialer(shared).Build()
+	second := kafko.NewReaderConfig(kafka.ReaderConfig{Topic: "payments"}).WithDialer(shared).Build()
+
+	assert.Same(t, shared.Dialer(), first.Dialer)
+	assert.Same(t, shared.Dialer(), second.Dialer)
+	assert.NotNil(t, shared.Dialer().TLS.ClientSessionCache)
+	assert.Nil(t, dialer.TLS.ClientSessionCache)
+	assert.Equal(t, shared.Dialer().SASLMechanism, shared.Transport().SASL)
+	assert.Same(t, shared.Dialer().TLS, shared.Transport().TLS)
+}
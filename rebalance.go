@@ -0,0 +1,98 @@
+package kafko
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// GenerationReader is implemented by the readers able to report the generation of their consumer
+// group membership and the partitions assigned to them, e.g. readers built on kafka.ConsumerGroup.
+// *kafka.Reader doesn't expose them, so NewListener refuses the options relying on them with it, and
+// Generation never reports a generation.
+type GenerationReader interface {
+	Generation() (generationID int32, assignments []TopicPartition)
+}
+
+// PartitionsHook is called with the partitions assigned to or revoked from the Listener.
+type PartitionsHook func(partitions []TopicPartition)
+
+func nopPartitionsHook([]TopicPartition) {}
+
+// checkGeneration calls the rebalance hooks if the generation of the reader changed since the last
+// check. The uncommitted messages are committed before revoking the partitions of the previous
+// generation, except, after a reconnection, the ones of the partitions the new generation doesn't
+// assign anymore, see releaseUnowned. It must be called with the processing lock held, so the
+// processing is paused meanwhile.
+func (listener *Listener) checkGeneration(ctx context.Context) {
+	reader, ok := listener.currentReader().(GenerationReader)
+	if !ok {
+		return
+	}
+
+	reconnected := listener.reconnected
+	listener.reconnected = false
+
+	generationID, assignments := reader.Generation()
+	if listener.hasGeneration && generationID == listener.generationID {
+		return
+	}
+
+	if listener.hasGeneration {
+		listener.opts.metricRebalances.Inc()
+
+		if reconnected {
+			listener.releaseUnowned(assignments)
+		}
+
+		if err := listener.commitUncommittedMessages(ctx); err != nil {
+			listener.log.Errorf(err, "err := listener.commitUncommittedMessages(ctx) (checkGeneration)")
+		}
+
+		listener.opts.onPartitionsRevoked(listener.assignments)
+	}
+
+	listener.hasGeneration = true
+	listener.generationID = generationID
+	atomic.StoreInt64(&listener.generation, int64(generationID))
+	listener.assignments = assignments
+	listener.checkpointed = nil
+
+	listener.opts.onPartitionsAssigned(assignments)
+}
+
+// releaseUnowned discards from the uncommitted messages the ones of the partitions not in the given
+// assignments, as the fresh reader of a reconnection can't commit them anymore, and counts them, see
+// WithMetricMessagesReleased. They were already processed, so they aren't handed to the dropped message
+// handler: the new owner of the partition redelivers them.
+func (listener *Listener) releaseUnowned(assignments []TopicPartition) {
+	owned := make(map[TopicPartition]bool, len(assignments))
+	for _, partition := range assignments {
+		owned[partition] = true
+	}
+
+	listener.uncommittedMsgsMutex.Lock()
+
+	kept := []uncommittedOffset{}
+	unowned := []uncommittedOffset{}
+
+	for _, offset := range listener.uncommittedMsgs {
+		if owned[TopicPartition{Topic: offset.topic, Partition: offset.partition}] {
+			kept = append(kept, offset)
+		} else {
+			unowned = append(unowned, offset)
+		}
+	}
+
+	listener.uncommittedMsgs = kept
+	listener.uncommittedMsgsMutex.Unlock()
+
+	if len(unowned) == 0 {
+		return
+	}
+
+	listener.log.Printf("Releasing %d uncommitted messages of partitions no longer assigned after reconnecting", len(unowned))
+
+	for range unowned {
+		listener.opts.metricMessagesReleased.Inc()
+	}
+}
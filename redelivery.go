@@ -0,0 +1,36 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type redeliveryKey struct{}
+
+// IsRedelivery reports whether the message handled with ctx was likely delivered before without
+// being committed, e.g. fetched again after a reconnection, so the handler can apply extra caution
+// or deduplicate it. ctx is the one given to the Handler.
+func IsRedelivery(ctx context.Context) bool {
+	redelivery, _ := ctx.Value(redeliveryKey{}).(bool)
+
+	return redelivery
+}
+
+// trackDelivery records the offset of the fetched message and marks ctx if the message was delivered
+// before, that is if its offset isn't beyond the highest offset delivered of its partition.
+// It must be called with the processing lock held.
+func (listener *Listener) trackDelivery(ctx context.Context, message kafka.Message) context.Context {
+	partition := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+
+	highest, delivered := listener.deliveredOffsets[partition]
+	if !delivered || message.Offset > highest {
+		listener.deliveredOffsets[partition] = message.Offset
+
+		return ctx
+	}
+
+	debugf(listener.log, "Message redelivered (topic = %s, partition = %d, offset = %d)", message.Topic, message.Partition, message.Offset)
+
+	return context.WithValue(ctx, redeliveryKey{}, true)
+}
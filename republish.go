@@ -0,0 +1,59 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// RepublishHeaders returns the headers to add to a message republished to a dead-letter or retry topic,
+// e.g. tracing or provenance headers, given the original message and why it's republished.
+type RepublishHeaders func(original kafka.Message, reason error) []kafka.Header
+
+func nopRepublishHeaders(kafka.Message, error) []kafka.Header { return nil }
+
+type dropKey struct{}
+
+// drop is what the Listener tells the dropped message handler through its ctx.
+type drop struct {
+	reason  error
+	headers RepublishHeaders
+}
+
+// DropReason returns why the message handled with ctx was dropped, e.g. the error of the handler or
+// ErrProcessingTimeout, or nil if it wasn't. ctx is the one given to the dropped message handler.
+func DropReason(ctx context.Context) error {
+	drop, _ := ctx.Value(dropKey{}).(drop)
+
+	return drop.reason
+}
+
+// republishHeaders returns the function set by WithRepublishHeaders of the Listener dropping the message
+// handled with ctx, so NewDLQHandler shares it.
+func republishHeaders(ctx context.Context) RepublishHeaders {
+	drop, ok := ctx.Value(dropKey{}).(drop)
+	if !ok {
+		return nopRepublishHeaders
+	}
+
+	return drop.headers
+}
+
+// withDropReason returns the ctx given to the dropped message handler, see DropReason.
+func (listener *Listener) withDropReason(ctx context.Context, reason error) context.Context {
+	return context.WithValue(ctx, dropKey{}, drop{reason: reason, headers: listener.opts.republishHeaders})
+}
+
+// mergeHeaders adds the extra headers to the given ones, except the ones whose key is already set,
+// so the headers of the original message are never overwritten.
+func mergeHeaders(headers, extra []kafka.Header) []kafka.Header {
+	merged := headers
+
+	for _, header := range extra {
+		if _, found := headerValue(kafka.Message{Headers: headers}, header.Key); !found {
+			merged = append(merged, header)
+		}
+	}
+
+	return merged
+}
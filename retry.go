@@ -0,0 +1,154 @@
+package kafko
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// The headers written to the messages republished to a retry topic, see WithRetryTopics.
+const (
+	RetryHeaderAttempts  = "x-retry-attempts"   // Number of retries of the message so far.
+	RetryHeaderNotBefore = "x-retry-not-before" // Unix time, in milliseconds, before which the message isn't delivered.
+)
+
+// retryTier is a retry topic and the delay before its messages are delivered.
+type retryTier struct {
+	delay time.Duration
+	topic string
+}
+
+// newRetryTiers returns the retry tiers sorted by delay.
+func newRetryTiers(topics map[time.Duration]string) []retryTier {
+	tiers := make([]retryTier, 0, len(topics))
+	for delay, topic := range topics {
+		tiers = append(tiers, retryTier{delay: delay, topic: topic})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].delay < tiers[j].delay
+	})
+
+	return tiers
+}
+
+// retryAttempts returns the number of retries of the message so far, 0 if it was never retried.
+func retryAttempts(message kafka.Message) int {
+	value, found := headerValue(message, RetryHeaderAttempts)
+	if !found {
+		return 0
+	}
+
+	attempts, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+
+	return attempts
+}
+
+// retryMessages republishes the nacked messages to their next retry tier, or hands the messages that
+// went through every tier to the dropped message handler, and returns the ones that must be committed.
+// The messages that couldn't be republished are skipped without being committed, like without retry topics.
+func (listener *Listener) retryMessages(ctx context.Context, messages []kafka.Message, reason error) []kafka.Message {
+	tiers := listener.opts.retryTiers
+	processed := []kafka.Message{}
+
+	for _, message := range messages {
+		attempts := retryAttempts(message)
+		if attempts >= len(tiers) {
+			processed = append(processed, listener.dropMessages(ctx, []kafka.Message{message}, reason)...)
+
+			continue
+		}
+
+		tier := tiers[attempts]
+		if err := listener.republish(ctx, message, tier, attempts+1, reason); err != nil {
+			listener.opts.metricErrors.Inc()
+			listener.log.Errorf(err, "err := listener.republish(ctx, message, tier, attempts+1, reason)")
+
+			continue
+		}
+
+		processed = append(processed, message)
+	}
+
+	return processed
+}
+
+// failMessages handles the messages a handler failed to process with reason: they're scheduled to their
+// next retry tier if WithRetryTopics is set, like a Nack, otherwise they're processed as dropped messages.
+// It returns the ones that must be committed.
+func (listener *Listener) failMessages(ctx context.Context, messages []kafka.Message, reason error) []kafka.Message {
+	if len(listener.opts.retryTiers) == 0 {
+		return listener.dropMessages(ctx, messages, reason)
+	}
+
+	return listener.retryMessages(ctx, messages, reason)
+}
+
+// republish writes the message to the topic of the retry tier with the given number of attempts.
+// reason is why the message was nacked, given to the function set by WithRepublishHeaders.
+func (listener *Listener) republish(ctx context.Context, message kafka.Message, tier retryTier, attempts int, reason error) error {
+	headers := make([]kafka.Header, 0, len(message.Headers)+2)
+
+	for _, header := range message.Headers {
+		if header.Key != RetryHeaderAttempts && header.Key != RetryHeaderNotBefore {
+			headers = append(headers, header)
+		}
+	}
+
+	notBefore := time.Now().Add(tier.delay).UnixMilli()
+	headers = append(headers,
+		kafka.Header{Key: RetryHeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: RetryHeaderNotBefore, Value: []byte(strconv.FormatInt(notBefore, 10))},
+	)
+
+	retry := kafka.Message{
+		Topic:   tier.topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: mergeHeaders(headers, listener.opts.republishHeaders(message, reason)),
+	}
+
+	if err := listener.opts.retryWriter.WriteMessages(ctx, retry); err != nil {
+		return errors.Wrapf(err, "err := listener.opts.retryWriter.WriteMessages(ctx, retry) (topic = %s)", tier.topic)
+	}
+
+	return nil
+}
+
+// awaitRetryDelay waits until the message of a retry topic is due, see RetryHeaderNotBefore. It returns
+// errExitProcessingLoop if the shutdown starts meanwhile, the message being redelivered after the restart.
+func (listener *Listener) awaitRetryDelay(ctx context.Context, message kafka.Message) error {
+	value, found := headerValue(message, RetryHeaderNotBefore)
+	if !found {
+		return nil
+	}
+
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil //nolint:nilerr // A malformed header doesn't delay the message.
+	}
+
+	wait := time.Until(time.UnixMilli(milliseconds))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-listener.shuttingDownCh:
+		return errExitProcessingLoop
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (awaitRetryDelay)")
+	}
+}
@@ -0,0 +1,49 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	ErrNoRoute = errors.New("no handler for the routing header")
+)
+
+// headerValue returns the value of the first header of the message with the given key.
+func headerValue(message kafka.Message, key string) (string, bool) {
+	for _, header := range message.Headers {
+		if header.Key == key {
+			return string(header.Value), true
+		}
+	}
+
+	return "", false
+}
+
+// withoutValue returns a Handler that hands the messages to handler without their value, see WithHeadersOnly.
+func withoutValue(handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		message.Value = nil
+
+		return handler(ctx, message)
+	}
+}
+
+// routeByHeader returns a Handler that dispatches every message to the route matching the value
+// of the given header. Unmatched messages are handed to fallback or, if it's nil, dropped.
+func routeByHeader(header string, routes map[string]Handler, fallback Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		value, found := headerValue(message, header)
+		if handler, ok := routes[value]; found && ok {
+			return handler(ctx, message)
+		}
+
+		if fallback != nil {
+			return fallback(ctx, message)
+		}
+
+		return errors.Wrapf(ErrNoRoute, "(routeByHeader) %s = %q", header, value)
+	}
+}
@@ -0,0 +1,45 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// acquireProcessingSlot waits for a free slot of the semaphore set by WithProcessingSemaphore, or
+// for ctx to be done. It returns right away if no semaphore is set.
+func (listener *Listener) acquireProcessingSlot(ctx context.Context) error {
+	if listener.processingSlots == nil {
+		return nil
+	}
+
+	select {
+	case listener.processingSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (acquireProcessingSlot)")
+	}
+}
+
+// releaseProcessingSlot frees the slot taken by acquireProcessingSlot.
+func (listener *Listener) releaseProcessingSlot() {
+	if listener.processingSlots == nil {
+		return
+	}
+
+	<-listener.processingSlots
+}
+
+// limitProcessing returns a processTick that holds a slot of the semaphore set by WithProcessingSemaphore
+// while calling processTick, so from the fetch of a message until its acknowledgement.
+func (listener *Listener) limitProcessing(processTick func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := listener.acquireProcessingSlot(ctx); err != nil {
+			return err
+		}
+
+		defer listener.releaseProcessingSlot()
+
+		return processTick(ctx)
+	}
+}
@@ -0,0 +1,66 @@
+package kafko
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// sharedTransports are the transports of the open SharedDialers by dialer, see transportFromDialer.
+var sharedTransports sync.Map
+
+// SharedDialer lets the listeners, and the publishers, of a process reuse the connections and the
+// authentication to the same cluster instead of each dialing its own. Its dialer, set on the readers
+// with ReaderConfigBuilder.WithDialer, shares the TLS sessions, so the connections of every reader,
+// reconnections included, resume a TLS session instead of negotiating a new one. Its transport pools
+// the connections of the writers and of the admin requests, e.g. FetchCommittedOffsets.
+//
+// kafka-go readers don't pool their connections, so each reader still holds its own; the savings are
+// in the handshakes and in the writers and admin requests. The SharedDialer must outlive the listeners
+// and publishers using it: its owner closes it once all of them are shut down.
+type SharedDialer struct {
+	dialer    *kafka.Dialer
+	transport *kafka.Transport
+}
+
+// NewSharedDialer creates a SharedDialer from the given dialer, e.g. NewDialer or NewOAuthDialer,
+// which it takes over: it must not be modified afterwards.
+func NewSharedDialer(dialer *kafka.Dialer) *SharedDialer {
+	shared := *dialer
+
+	// Resume the TLS sessions across the connections, unless the dialer already does.
+	if shared.TLS != nil && shared.TLS.ClientSessionCache == nil {
+		shared.TLS = shared.TLS.Clone()
+		shared.TLS.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	transport := &kafka.Transport{
+		DialTimeout: shared.Timeout,
+		ClientID:    shared.ClientID,
+		TLS:         shared.TLS,
+		SASL:        shared.SASLMechanism,
+	}
+
+	sharedTransports.Store(&shared, transport)
+
+	return &SharedDialer{dialer: &shared, transport: transport}
+}
+
+// Dialer returns the dialer shared by the readers, see ReaderConfigBuilder.WithDialer.
+func (shared *SharedDialer) Dialer() *kafka.Dialer {
+	return shared.dialer
+}
+
+// Transport returns the transport pooling the connections of the writers and of the admin requests,
+// e.g. to set kafka.Writer.Transport.
+func (shared *SharedDialer) Transport() *kafka.Transport {
+	return shared.transport
+}
+
+// Close closes the idle pooled connections. The readers close their own connections once their
+// listeners are shut down, so Close must be called after shutting down every listener and publisher.
+func (shared *SharedDialer) Close() {
+	sharedTransports.Delete(shared.dialer)
+	shared.transport.CloseIdleConnections()
+}
@@ -0,0 +1,55 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// oversized reports whether the value of the message exceeds the size set by WithMaxMessageSize.
+func (listener *Listener) oversized(message kafka.Message) bool {
+	return listener.opts.maxMessageSize > 0 && len(message.Value) > listener.opts.maxMessageSize
+}
+
+// dropOversized hands the oversized message to the dropped message handler. It always returns nil,
+// so the message is committed even if the dropped message handler fails.
+func (listener *Listener) dropOversized(ctx context.Context, message kafka.Message) error {
+	listener.opts.metricMessagesOversized.Inc()
+	listener.reportOutcome(OutcomeDropped, message)
+
+	listener.log.Printf("Dropping oversized message (partition = %d, offset = %d, size = %d)",
+		message.Partition, message.Offset, len(message.Value))
+
+	reason := errors.Wrapf(ErrMessageDropped, "oversized message (size = %d)", len(message.Value))
+
+	if err := listener.opts.processDroppedMsg(listener.withDropReason(ctx, reason), &message, listener.log); err != nil {
+		listener.log.Errorf(err, "Failed to process oversized message")
+	}
+
+	return nil
+}
+
+// limitSize returns a Handler that drops the oversized messages and hands the rest to handler.
+// The oversized messages are committed in turn like the processed ones, see WithOrderedConcurrency.
+func (listener *Listener) limitSize(handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if listener.oversized(message) {
+			return listener.dropOversized(ctx, message)
+		}
+
+		return handler(ctx, message)
+	}
+}
+
+// deliverLimited returns a deliver function for processTick that drops and commits the oversized
+// messages, like ListenFunc, and delivers the rest of the messages with deliver.
+func (listener *Listener) deliverLimited(deliver Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if listener.oversized(message) {
+			return listener.processHandler(ctx, message, listener.dropOversized)
+		}
+
+		return deliver(ctx, message)
+	}
+}
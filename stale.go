@@ -0,0 +1,15 @@
+package kafko
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// stale reports whether the message is older than the age set by WithMaxMessageAge. The messages
+// without time, e.g. produced by old clients, are never stale.
+func (listener *Listener) stale(message kafka.Message) bool {
+	if listener.opts.maxMessageAge <= 0 || message.Time.IsZero() {
+		return false
+	}
+
+	return listener.opts.now().Sub(message.Time) > listener.opts.maxMessageAge
+}
@@ -0,0 +1,78 @@
+package kafko
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Stats is a snapshot of the state of a Listener.
+type Stats struct {
+	Name              string    // Name of the listener as set by WithName.
+	LastCommittedTime time.Time // Time of the newest committed message, zero if none was committed yet.
+	Goroutines        int       // Running goroutines started by the listener, see spawn.
+	ShutdownReason    string    // Reason given to ShutdownWithReason, empty if none.
+	Reconnects        int       // Successful reconnections to Kafka, see WithMetricReconnects.
+	LastReconnect     time.Time // Time of the last successful reconnection, zero if none.
+}
+
+// Stats returns a snapshot of the state of the Listener.
+func (listener *Listener) Stats() Stats {
+	listener.uncommittedMsgsMutex.Lock()
+	defer listener.uncommittedMsgsMutex.Unlock()
+
+	return Stats{
+		Name:              listener.opts.name,
+		LastCommittedTime: listener.lastCommittedTime,
+		Goroutines:        int(atomic.LoadInt32(&listener.goroutines)),
+		ShutdownReason:    listener.shutdownReason,
+		Reconnects:        listener.reconnects,
+		LastReconnect:     listener.lastReconnect,
+	}
+}
+
+// IdleFor returns the time elapsed since the last message was fetched or, if none was, since the Listener
+// was created, e.g. for an autoscaler to scale the consumers down during the quiet periods.
+func (listener *Listener) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&listener.lastFetch)))
+}
+
+// spawn runs fn in a goroutine counted by Stats. Every goroutine of the Listener is started through it:
+// per Listen, there's at most the context watcher, the commit loop, the prefetcher, the uncommitted sampler
+// and the WithOrderedConcurrency workers, bounded by their slots, along with the ones of RunAsync, Drain,
+// Shutdown and the Multiplexer, one per call or per Listener. The metrics, the hooks and
+// the other side effects don't need a worker of their own to be serialized, as they run synchronously on
+// the goroutine that triggers them. So the count doesn't grow with the throughput.
+func (listener *Listener) spawn(fn func()) {
+	atomic.AddInt32(&listener.goroutines, 1)
+
+	go func() {
+		defer atomic.AddInt32(&listener.goroutines, -1)
+
+		fn()
+	}()
+}
+
+// trackReconnect records a successful reconnection, see Stats.
+func (listener *Listener) trackReconnect() {
+	listener.uncommittedMsgsMutex.Lock()
+	defer listener.uncommittedMsgsMutex.Unlock()
+
+	listener.reconnects++
+	listener.lastReconnect = time.Now()
+}
+
+// trackCommitted records the time of the newest committed message. It must be called
+// with the uncommittedMsgsMutex locked.
+func (listener *Listener) trackCommitted(messages []kafka.Message) {
+	for _, message := range messages {
+		if message.Time.After(listener.lastCommittedTime) {
+			listener.lastCommittedTime = message.Time
+		}
+	}
+
+	if !listener.lastCommittedTime.IsZero() {
+		listener.opts.metricLastCommittedTime.Set(float64(listener.lastCommittedTime.UnixNano()) / float64(time.Second))
+	}
+}
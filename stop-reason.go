@@ -0,0 +1,41 @@
+package kafko
+
+// StopReason tells why the Listener stopped listening, see ListenWithReason.
+type StopReason int
+
+const (
+	// StopError means the Listener stopped because of an unrecoverable error.
+	StopError StopReason = iota
+	// StopShutdown means the Listener stopped because Shutdown was called.
+	StopShutdown
+	// StopContextCanceled means the Listener stopped because the context given to Listen was canceled.
+	StopContextCanceled
+	// StopChannelClosed means the Listener stopped because its message channel was closed.
+	StopChannelClosed
+)
+
+// String returns the name of the StopReason.
+func (reason StopReason) String() string {
+	switch reason {
+	case StopError:
+		return "error"
+	case StopShutdown:
+		return "shutdown"
+	case StopContextCanceled:
+		return "context canceled"
+	case StopChannelClosed:
+		return "channel closed"
+	}
+
+	return "unknown"
+}
+
+// canceledReason tells apart a cancellation caused by Shutdown from one caused by the caller.
+func (listener *Listener) canceledReason() StopReason {
+	select {
+	case <-listener.shuttingDownCh:
+		return StopShutdown
+	default:
+		return StopContextCanceled
+	}
+}
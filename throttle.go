@@ -0,0 +1,58 @@
+package kafko
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// throttle delays the next fetch while the heap exceeds the threshold set by WithAdaptiveThrottle.
+// The delay is cut short once the shutdown starts, and once ctx is done, returning its error.
+func (listener *Listener) throttle(ctx context.Context) error {
+	if listener.opts.memThreshold == 0 || !listener.overMemThreshold() {
+		return nil
+	}
+
+	timer := time.NewTimer(listener.opts.throttleDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-listener.shuttingDownCh:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "err := ctx.Err() (ctx.Done()) (throttle)")
+	}
+}
+
+// overMemThreshold reports whether the heap exceeded the threshold as of the last check,
+// checking it again once the throttle interval elapsed, as reading it stops the world.
+func (listener *Listener) overMemThreshold() bool {
+	listener.throttleMutex.Lock()
+	defer listener.throttleMutex.Unlock()
+
+	if time.Since(listener.lastMemCheck) < listener.opts.throttleInterval {
+		return listener.throttled
+	}
+
+	var stats runtime.MemStats
+
+	runtime.ReadMemStats(&stats)
+
+	throttled := stats.HeapAlloc > listener.opts.memThreshold
+
+	switch {
+	case throttled && !listener.throttled:
+		listener.log.Printf("Throttling the fetches (heap = %d bytes, threshold = %d bytes)", stats.HeapAlloc, listener.opts.memThreshold)
+	case !throttled && listener.throttled:
+		listener.log.Printf("Stopped throttling the fetches (heap = %d bytes, threshold = %d bytes)", stats.HeapAlloc, listener.opts.memThreshold)
+	}
+
+	listener.lastMemCheck = time.Now()
+	listener.throttled = throttled
+
+	return throttled
+}
@@ -0,0 +1,37 @@
+package kafko
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// IsTombstone reports whether the message is a tombstone, i.e. a message of a compacted topic with a
+// null value marking its key as deleted. A tombstone has a nil Value, unlike an empty message.
+func IsTombstone(message kafka.Message) bool {
+	return message.Value == nil
+}
+
+// routeTombstones returns a Handler that hands the tombstones to tombstoneHandler and the rest of
+// the messages to handler, see WithTombstoneHandler.
+func routeTombstones(tombstoneHandler, handler Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if IsTombstone(message) {
+			return tombstoneHandler(ctx, message)
+		}
+
+		return handler(ctx, message)
+	}
+}
+
+// deliverTombstones returns a deliver function for processTick that processes the tombstones with
+// the tombstone handler, like ListenFunc, and delivers the rest of the messages with deliver.
+func (listener *Listener) deliverTombstones(deliver Handler) Handler {
+	return func(ctx context.Context, message kafka.Message) error {
+		if IsTombstone(message) {
+			return listener.processHandler(ctx, message, listener.opts.tombstoneHandler)
+		}
+
+		return deliver(ctx, message)
+	}
+}
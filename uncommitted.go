@@ -0,0 +1,114 @@
+package kafko
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// uncommittedOffset is what's kept of a processed message until it's committed. Committing only
+// needs its topic, partition and offset, so the value, usually the bulk of the message, is not held
+// in memory. The key and headers are kept to identify the committed messages, see OutcomeHook.
+type uncommittedOffset struct {
+	topic     string
+	partition int
+	offset    int64
+	time      time.Time // Used by Stats, see trackCommitted.
+	key       []byte
+	headers   []kafka.Header
+}
+
+// newUncommittedOffsets returns the uncommitted offsets of the given messages.
+func newUncommittedOffsets(messages []kafka.Message) []uncommittedOffset {
+	offsets := make([]uncommittedOffset, 0, len(messages))
+
+	for _, message := range messages {
+		offsets = append(offsets, uncommittedOffset{
+			topic:     message.Topic,
+			partition: message.Partition,
+			offset:    message.Offset,
+			time:      message.Time,
+			key:       message.Key,
+			headers:   message.Headers,
+		})
+	}
+
+	return offsets
+}
+
+// uncommittedMessages builds the messages to commit from the given uncommitted offsets.
+// All but their value is set, see commitOffsets.
+func uncommittedMessages(offsets []uncommittedOffset) []kafka.Message {
+	messages := make([]kafka.Message, 0, len(offsets))
+
+	for _, offset := range offsets {
+		messages = append(messages, kafka.Message{
+			Topic:     offset.topic,
+			Partition: offset.partition,
+			Offset:    offset.offset,
+			Time:      offset.time,
+			Key:       offset.key,
+			Headers:   offset.headers,
+		})
+	}
+
+	return messages
+}
+
+// commitOffsets returns the given messages with only their topic, partition, offset and time set,
+// all the committers need.
+func commitOffsets(messages []kafka.Message) []kafka.Message {
+	offsets := make([]kafka.Message, 0, len(messages))
+
+	for _, message := range messages {
+		offsets = append(offsets, kafka.Message{
+			Topic:     message.Topic,
+			Partition: message.Partition,
+			Offset:    message.Offset,
+			Time:      message.Time,
+		})
+	}
+
+	return offsets
+}
+
+// highestOffsets keeps only the highest of the given offsets of every partition, which commits the
+// partition as far as all of them, in the order the partitions first appear.
+func highestOffsets(offsets []uncommittedOffset) []uncommittedOffset {
+	highest := make([]uncommittedOffset, 0, len(offsets))
+	indexes := map[TopicPartition]int{}
+
+	for _, offset := range offsets {
+		partition := TopicPartition{Topic: offset.topic, Partition: offset.partition}
+
+		index, ok := indexes[partition]
+		if !ok {
+			indexes[partition] = len(highest)
+			highest = append(highest, offset)
+
+			continue
+		}
+
+		if offset.offset > highest[index].offset {
+			highest[index] = offset
+		}
+	}
+
+	return highest
+}
+
+// sampleUncommitted reports the highest number of uncommitted messages since the last commit, see
+// WithMetricUncommittedDepth, and warns when it exceeds WithUncommittedWarnThreshold. The commit loop
+// samples before every commit attempt, so a stalled commit loop shows up as a growing depth.
+func (listener *Listener) sampleUncommitted() {
+	listener.uncommittedMsgsMutex.Lock()
+	peak := listener.peakUncommitted
+	listener.uncommittedMsgsMutex.Unlock()
+
+	listener.opts.metricUncommittedDepth.Set(float64(peak))
+
+	if threshold := listener.opts.uncommittedWarnThreshold; threshold > 0 && peak > threshold {
+		listener.log.Printf("Warning: %d messages left uncommitted since the last commit (threshold = %d), the commits may be stalled",
+			peak, threshold)
+	}
+}